@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// ------------------------------
+// Échantillonnage adaptatif : après un premier lot de camera.samples rayons
+// par pixel, seuls les pixels dont l'erreur relative estimée dépasse
+// Threshold reçoivent de nouveaux lots, jusqu'à MaxSamples. Chaque tuile
+// s'arrête quand tous ses pixels ont convergé, sans rien attendre des
+// autres : les clients du rendu distribué l'appliquent chacun de leur côté.
+// Un budget de temps (RenderOptions.Budget) borne de même l'affinage.
+type AdaptiveSampling struct {
+	Threshold  float32 // erreur relative visée, 0 désactive
+	MaxSamples int
+}
+
+// En dessous de cette luminance, l'erreur est jugée dans l'absolu : le bruit
+// dans les noirs ne se voit pas.
+const adaptiveFloor = 0.05
+
+// Échantillons du premier lot adaptatif : en deçà, la variance estimée est
+// trop peu fiable pour arrêter un pixel.
+const adaptiveMinSamples = 4
+
+func (a AdaptiveSampling) enabled() bool {
+	return a.Threshold > 0 && a.MaxSamples > 0
+}
+
+// pixelEstimate cumule les échantillons d'un pixel, avec la variance de leur
+// luminance (algorithme de Welford).
+type pixelEstimate struct {
+	sum   Vec3f
+	count int
+	mean  float32
+	m2    float32
+	rng   *Rng
+}
+
+func (e *pixelEstimate) add(v Vec3f) {
+	e.sum = Add(e.sum, v)
+	e.count++
+	l := luminance(v)
+	delta := l - e.mean
+	e.mean += delta / float32(e.count)
+	e.m2 += delta * (l - e.mean)
+}
+
+// relativeError : écart type de la moyenne rapporté à la moyenne.
+func (e *pixelEstimate) relativeError() float32 {
+	if e.count < 2 {
+		return float32(math.Inf(1))
+	}
+	variance := e.m2 / float32(e.count-1) / float32(e.count)
+	return sqrt32(variance) / max(e.mean, adaptiveFloor)
+}
+
+func (e *pixelEstimate) radiance() Vec3f {
+	return e.sum.mul(1 / float32(max(e.count, 1)))
+}
+
+// sampleTile estime la radiance des pixels [startX, endX[ × [startY, endY[
+// et renvoie le nombre total d'échantillons lancés. Sans échantillonnage
+// adaptatif ni budget, chaque pixel reçoit un lot de camera.samples rayons ;
+// sinon les lots s'enchaînent tant qu'il reste des pixels bruités (ou sans
+// fin, à défaut de seuil) et que l'échéance n'est pas atteinte. Le premier
+// lot est toujours complet, même hors délai.
+func sampleTile(scene Scene, camera Camera, startX, startY, endX, endY, width, height int, options RenderOptions) ([]Vec3f, int) {
+	adaptive := options.Adaptive.enabled()
+	batch := max(camera.samples, 1)
+	if adaptive {
+		batch = max(batch, adaptiveMinSamples)
+	}
+	budget := options.Budget > 0
+	deadline := time.Now().Add(options.Budget)
+	tileWidth := endX - startX
+	estimates := make([]pixelEstimate, tileWidth*(endY-startY))
+	done := func(e *pixelEstimate) bool {
+		switch {
+		case e.count == 0:
+			return false
+		case adaptive:
+			return e.count >= options.Adaptive.MaxSamples || e.relativeError() < options.Adaptive.Threshold
+		}
+		return !budget
+	}
+
+	samples := 0
+	for active := len(estimates); active > 0; {
+		active = 0
+		for i := range estimates {
+			e := &estimates[i]
+			if done(e) {
+				continue
+			}
+			if e.count > 0 && budget && time.Now().After(deadline) {
+				active = 0
+				break
+			}
+			x, y := startX+i%tileWidth, startY+i/tileWidth
+			if e.rng == nil {
+				e.rng = pixelRng(x, y, options.Seed)
+			}
+			// Chaque lot répartit ses rayons sur l'intervalle d'obturation
+			n := batch
+			if adaptive {
+				n = min(n, options.Adaptive.MaxSamples-e.count)
+			}
+			for s := 0; s < n; s++ {
+				ray := camera.ray(x, y, width, height, camera.sampleTime(s, n))
+				e.add(options.Integrator.radiance(scene, ray, e.rng))
+			}
+			samples += n
+			active++
+		}
+	}
+
+	radiance := make([]Vec3f, len(estimates))
+	for i := range estimates {
+		radiance[i] = estimates[i].radiance()
+	}
+	return radiance, samples
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ------------------------------
+type Interpolation int
+
+const (
+	Linear Interpolation = iota
+	Bezier
+)
+
+// Une clé d'animation. Pour une interpolation de Bézier, out et in sont les
+// points de contrôle (en valeur absolue) vers la clé suivante et depuis la
+// clé précédente.
+type Keyframe[T any] struct {
+	time          float32
+	value         T
+	in, out       T
+	interpolation Interpolation
+}
+
+func key[T any](time float32, value T) Keyframe[T] {
+	return Keyframe[T]{time: time, value: value, in: value, out: value, interpolation: Linear}
+}
+
+func bezierKey[T any](time float32, value, in, out T) Keyframe[T] {
+	return Keyframe[T]{time: time, value: value, in: in, out: out, interpolation: Bezier}
+}
+
+type Track[T any] struct {
+	keys []Keyframe[T]
+}
+
+func (tr *Track[T]) add(k Keyframe[T]) {
+	tr.keys = append(tr.keys, k)
+	sort.SliceStable(tr.keys, func(i, j int) bool { return tr.keys[i].time < tr.keys[j].time })
+}
+
+func (tr Track[T]) animated() bool {
+	return len(tr.keys) > 0
+}
+
+// sample renvoie la valeur de la piste au temps donné, ou fallback si la
+// piste est vide.
+func (tr Track[T]) sample(time float32, fallback T, lerp func(a, b T, w float32) T) T {
+	if len(tr.keys) == 0 {
+		return fallback
+	}
+	if time <= tr.keys[0].time {
+		return tr.keys[0].value
+	}
+	last := tr.keys[len(tr.keys)-1]
+	if time >= last.time {
+		return last.value
+	}
+
+	i := sort.Search(len(tr.keys), func(i int) bool { return tr.keys[i].time > time }) - 1
+	k0, k1 := tr.keys[i], tr.keys[i+1]
+	w := (time - k0.time) / (k1.time - k0.time)
+
+	if k0.interpolation == Bezier {
+		// Algorithme de De Casteljau
+		a := lerp(k0.value, k0.out, w)
+		b := lerp(k0.out, k1.in, w)
+		c := lerp(k1.in, k1.value, w)
+		return lerp(lerp(a, b, w), lerp(b, c, w), w)
+	}
+	return lerp(k0.value, k1.value, w)
+}
+
+func lerpVec3f(a, b Vec3f, w float32) Vec3f {
+	return Add(a.mul(1-w), b.mul(w))
+}
+
+func lerpFloat32(a, b float32, w float32) float32 {
+	return a*(1-w) + b*w
+}
+
+// ------------------------------
+type CameraAnimation struct {
+	position, up, at Track[Vec3f]
+}
+
+type MaterialAnimation struct {
+	ka, kd, ks Track[Vec3f]
+	n          Track[float32]
+}
+
+func (ma MaterialAnimation) apply(m Materials, time float32) Materials {
+	switch mat := m.(type) {
+	case Phong:
+		mat.ka = ma.ka.sample(time, mat.ka, lerpVec3f)
+		mat.kd = ma.kd.sample(time, mat.kd, lerpVec3f)
+		mat.ks = ma.ks.sample(time, mat.ks, lerpVec3f)
+		mat.n = ma.n.sample(time, mat.n, lerpFloat32)
+		return mat
+	case Lambert:
+		mat.kd = ma.kd.sample(time, mat.kd, lerpVec3f)
+		return mat
+	}
+	return m
+}
+
+// translation, rotation (angles d'Euler en radians) et scale s'appliquent
+// aux objets Transformed, avant leur propre transformation.
+type ObjectAnimation struct {
+	position                     Track[Vec3f]
+	radius                       Track[float32]
+	translation, rotation, scale Track[Vec3f]
+	material                     MaterialAnimation
+}
+
+// apply renvoie l'objet au temps donné, ou nil s'il disparaît de l'image :
+// une échelle nulle ne peut pas être appliquée aux rayons.
+func (oa ObjectAnimation) apply(object GeometricObject, time float32) GeometricObject {
+	switch obj := object.(type) {
+	case Sphere:
+		obj.position = oa.position.sample(time, obj.position, lerpVec3f)
+		obj.radius = oa.radius.sample(time, obj.radius, lerpFloat32)
+		obj.Material = oa.material.apply(obj.Material, time)
+		return obj
+	case Moving:
+		obj.object = oa.apply(obj.object, time)
+		if obj.object == nil {
+			return nil
+		}
+		return obj
+	case Transformed:
+		if oa.translation.animated() || oa.rotation.animated() || oa.scale.animated() {
+			t := oa.translation.sample(time, Vec3f{0, 0, 0}, lerpVec3f)
+			r := oa.rotation.sample(time, Vec3f{0, 0, 0}, lerpVec3f)
+			s := oa.scale.sample(time, Vec3f{1, 1, 1}, lerpVec3f)
+			transformed, ok := NewTransformed(obj.object, trs(t, r, s).mul(obj.toWorld))
+			if !ok {
+				return nil
+			}
+			return transformed
+		}
+		return obj
+	}
+	return object
+}
+
+// motion renvoie le déplacement de l'objet pendant l'image qui commence au
+// temps donné, pour le flou de bougé. Seules les pistes de position des
+// sphères et de translation des objets transformés sont suivies : rotation
+// et échelle restent figées sur la durée de l'image.
+func (oa ObjectAnimation) motion(object GeometricObject, time float32) (Motion, bool) {
+	switch object.(type) {
+	case Sphere:
+		if oa.position.animated() {
+			return KeyframedMotion{oa.position, time}, true
+		}
+	case Transformed:
+		if oa.translation.animated() {
+			return KeyframedMotion{oa.translation, time}, true
+		}
+	}
+	return nil, false
+}
+
+// NodeAnimation anime un nœud du graphe de scène : translation, rotation et
+// scale précèdent sa transformation locale, et le nœud est caché tant que
+// visible vaut moins de 0,5.
+type NodeAnimation struct {
+	translation, rotation, scale Track[Vec3f]
+	visible                      Track[float32]
+}
+
+func (na NodeAnimation) apply(n *Node, time float32) {
+	if na.translation.animated() || na.rotation.animated() || na.scale.animated() {
+		t := na.translation.sample(time, Vec3f{0, 0, 0}, lerpVec3f)
+		r := na.rotation.sample(time, Vec3f{0, 0, 0}, lerpVec3f)
+		s := na.scale.sample(time, Vec3f{1, 1, 1}, lerpVec3f)
+		n.setTransform(trs(t, r, s).mul(n.transform))
+	}
+	if na.visible.animated() {
+		if na.visible.sample(time, 1, lerpFloat32) < 0.5 {
+			n.hide()
+		} else {
+			n.show()
+		}
+	}
+}
+
+type LightAnimation struct {
+	color, position Track[Vec3f]
+}
+
+// apply anime la couleur de toute source, et la position de celles qui en ont une.
+func (la LightAnimation) apply(light LightSource, time float32) LightSource {
+	switch l := light.(type) {
+	case Light:
+		l.color = la.color.sample(time, l.color, lerpVec3f)
+		l.position = la.position.sample(time, l.position, lerpVec3f)
+		return l
+	case PointLight:
+		l.color = la.color.sample(time, l.color, lerpVec3f)
+		l.position = la.position.sample(time, l.position, lerpVec3f)
+		return l
+	case SpotLight:
+		l.color = la.color.sample(time, l.color, lerpVec3f)
+		l.position = la.position.sample(time, l.position, lerpVec3f)
+		return l
+	case DirectionalLight:
+		l.color = la.color.sample(time, l.color, lerpVec3f)
+		return l
+	}
+	return light
+}
+
+type Animation struct {
+	fps     float32
+	camera  CameraAnimation
+	objects map[int]*ObjectAnimation
+	lights  map[int]*LightAnimation
+	nodes   map[string]*NodeAnimation // par chemin dans le graphe de scène
+}
+
+func NewAnimation(fps float32) Animation {
+	return Animation{
+		fps:     fps,
+		objects: make(map[int]*ObjectAnimation),
+		lights:  make(map[int]*LightAnimation),
+		nodes:   make(map[string]*NodeAnimation),
+	}
+}
+
+func (a *Animation) object(index int) *ObjectAnimation {
+	if _, ok := a.objects[index]; !ok {
+		a.objects[index] = &ObjectAnimation{}
+	}
+	return a.objects[index]
+}
+
+func (a *Animation) light(index int) *LightAnimation {
+	if _, ok := a.lights[index]; !ok {
+		a.lights[index] = &LightAnimation{}
+	}
+	return a.lights[index]
+}
+
+func (a *Animation) node(path string) *NodeAnimation {
+	if _, ok := a.nodes[path]; !ok {
+		a.nodes[path] = &NodeAnimation{}
+	}
+	return a.nodes[path]
+}
+
+func (a Animation) frameTime(frame int) float32 {
+	return float32(frame) / a.fps
+}
+
+// evaluate renvoie une copie de la scène et de la caméra au temps donné.
+// La scène d'origine n'est pas modifiée. Si l'obturateur reste ouvert, les
+// objets animés bougent pendant l'image le long de leur piste.
+func (a Animation) evaluate(scene Scene, camera Camera, time float32) (Scene, Camera) {
+	camera.position = a.camera.position.sample(time, camera.position, lerpVec3f)
+	camera.up = a.camera.up.sample(time, camera.up, lerpVec3f)
+	camera.at = a.camera.at.sample(time, camera.at, lerpVec3f)
+
+	frame := scene
+	frame.objects = append([]GeometricObject(nil), scene.objects...)
+	frame.lights = append([]LightSource(nil), scene.lights...)
+	for i, oa := range a.objects {
+		if i < len(frame.objects) {
+			object := frame.objects[i]
+			frame.objects[i] = oa.apply(object, time)
+			if motion, ok := oa.motion(object, time); ok && frame.objects[i] != nil && camera.shutter.close > camera.shutter.open {
+				frame.objects[i] = Moving{frame.objects[i], motion}
+			}
+		}
+	}
+	frame.objects = slices.DeleteFunc(frame.objects, func(o GeometricObject) bool { return o == nil })
+	for i, la := range a.lights {
+		if i < len(frame.lights) {
+			frame.lights[i] = la.apply(frame.lights[i], time)
+		}
+	}
+	if scene.root != nil && len(a.nodes) > 0 {
+		frame.root = scene.root.clone()
+		for path, na := range a.nodes {
+			if n := frame.root.find(path); n != nil {
+				na.apply(n, time)
+			}
+		}
+	}
+	return frame, camera
+}
+
+func renderSequence(scene Scene, camera Camera, animation Animation, first, last, width, height int, pattern string, options RenderOptions) error {
+	for frame := first; frame <= last; frame++ {
+		frameScene, frameCamera := animation.evaluate(scene, camera, animation.frameTime(frame))
+
+		img := Image{make([]rgbRepresentation, width*height), width, height}
+		layers, samples := renderFrame(img, frameCamera, frameScene, options)
+
+		path := fmt.Sprintf(pattern, frame)
+		if err := img.save(path); err != nil {
+			return fmt.Errorf("failed to save frame %d: %v", frame, err)
+		}
+		if err := saveAOVs(strings.TrimSuffix(path, filepath.Ext(path)), layers, width, height, options.AOVFormat); err != nil {
+			return fmt.Errorf("failed to save AOVs of frame %d: %v", frame, err)
+		}
+		fmt.Printf("Frame %d saved as %s (%.1f samples per pixel)\n", frame, path, samplesPerPixel(samples, width, height))
+	}
+	return nil
+}
+
+// Animation de démonstration pour la scène de populateSceneWithPhong :
+// la caméra tourne autour de la scène, la sphère rouge monte et descend,
+// la lumière principale passe du blanc à l'orange, la forêt sort du sol et
+// un premier sapin apparaît au bout d'une demi-seconde.
+func populateAnimation(fps float32) Animation {
+	animation := NewAnimation(fps)
+
+	animation.camera.position.add(bezierKey(0, Vec3f{0, 0, -5}, Vec3f{0, 0, -5}, Vec3f{-4, 0, -3}))
+	animation.camera.position.add(bezierKey(2, Vec3f{-6, 1, 2}, Vec3f{-6, 1, -1}, Vec3f{-6, 1, 2}))
+
+	red := animation.object(0)
+	red.position.add(bezierKey(0, Vec3f{0, 0, 8}, Vec3f{0, 0, 8}, Vec3f{0, 2, 8}))
+	red.position.add(bezierKey(1, Vec3f{0, 2, 8}, Vec3f{0, 2, 8}, Vec3f{0, 2, 8}))
+	red.position.add(key(2, Vec3f{0, 0, 8}))
+	red.material.n.add(key(0, float32(32)))
+	red.material.n.add(key(2, float32(4)))
+
+	animation.light(0).color.add(key(0, Vec3f{1.0, 1.0, 1.0}))
+	animation.light(0).color.add(key(2, Vec3f{1.0, 0.6, 0.2}))
+
+	forest := animation.node("foret")
+	forest.translation.add(key(0, Vec3f{0, -3, 0}))
+	forest.translation.add(key(2, Vec3f{0, 0, 0}))
+	first := animation.node("foret/sapin0")
+	first.visible.add(key(0, float32(0)))
+	first.visible.add(key(1, float32(1)))
+
+	return animation
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	refl "reflect"
+	"strings"
+)
+
+// ------------------------------
+// Passes AOV (arbitrary output variables) rendues avec l'image finale, pour
+// le compositing : profondeur linéaire, normale monde, albédo, identifiants
+// d'objet et de matériau, et contribution de chaque source.
+const (
+	AOVDepth      = "depth"
+	AOVNormal     = "normal"
+	AOVAlbedo     = "albedo"
+	AOVObjectID   = "objectid"
+	AOVMaterialID = "materialid"
+	AOVLights     = "lights"
+)
+
+var aovNames = []string{AOVDepth, AOVNormal, AOVAlbedo, AOVObjectID, AOVMaterialID, AOVLights}
+
+// AOVSet : passes demandées, dans l'ordre de aovNames.
+type AOVSet []string
+
+// parseAOVs lit une liste séparée par des virgules ("all" les demande toutes).
+func parseAOVs(list string) (AOVSet, error) {
+	requested := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+		case name == "all":
+			for _, n := range aovNames {
+				requested[n] = true
+			}
+		case contains(aovNames, name):
+			requested[name] = true
+		default:
+			return nil, fmt.Errorf("unknown AOV %q, expected one of %s", name, strings.Join(aovNames, ", "))
+		}
+	}
+	var set AOVSet
+	for _, n := range aovNames {
+		if requested[n] {
+			set = append(set, n)
+		}
+	}
+	return set, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AOVSet) has(name string) bool {
+	return contains(a, name)
+}
+
+// AOVLayer : une passe en flottants, channels composantes par pixel. Les
+// champs sont exportés pour voyager dans RenderResult.
+type AOVLayer struct {
+	Name     string
+	Channels int
+	Data     []float32
+}
+
+func (l AOVLayer) set(index int, v Vec3f) {
+	c := l.Data[index*l.Channels : (index+1)*l.Channels]
+	c[0] = v.x
+	if l.Channels == 3 {
+		c[1], c[2] = v.y, v.z
+	}
+}
+
+// Nom de la couche qui porte l'image finale en flottants
+const beautyLayer = "beauty"
+
+// ------------------------------
+// aovRenderer évalue les passes d'un pixel. Les passes géométriques suivent
+// un rayon unique par le centre du pixel, à l'ouverture de l'obturateur ;
+// la passe de chaque source relance l'intégrateur du rendu dans une
+// copie de la scène éclairée par cette seule source (les surfaces
+// émissives vues directement apparaissent donc dans chacune).
+type aovRenderer struct {
+	set         AOVSet
+	lightScenes []Scene
+	lightNames  []string
+}
+
+// newAOVRenderer attend une scène préparée.
+func newAOVRenderer(scene Scene, set AOVSet) aovRenderer {
+	r := aovRenderer{set: set}
+	if !set.has(AOVLights) {
+		return r
+	}
+	only := func(name string) Scene {
+		s := scene
+		s.lights, s.areaLights, s.environment = nil, nil, nil
+		r.lightNames = append(r.lightNames, name)
+		return s
+	}
+	for i, light := range scene.lights {
+		s := only(fmt.Sprintf("light%d", i))
+		s.lights = []LightSource{light}
+		r.lightScenes = append(r.lightScenes, s)
+	}
+	for i, light := range scene.areaLights {
+		s := only(fmt.Sprintf("area%d", i))
+		s.areaLights = []AreaLight{light}
+		r.lightScenes = append(r.lightScenes, s)
+	}
+	if scene.environment != nil {
+		s := only("environment")
+		s.environment = scene.environment
+		r.lightScenes = append(r.lightScenes, s)
+	}
+	return r
+}
+
+// layers alloue les couches d'une zone de count pixels, image finale en tête.
+func (r aovRenderer) layers(count int) []AOVLayer {
+	if len(r.set) == 0 {
+		return nil
+	}
+	layer := func(name string, channels int) AOVLayer {
+		return AOVLayer{name, channels, make([]float32, count*channels)}
+	}
+	layers := []AOVLayer{layer(beautyLayer, 3)}
+	for _, name := range r.set {
+		switch name {
+		case AOVDepth, AOVObjectID, AOVMaterialID:
+			layers = append(layers, layer(name, 1))
+		case AOVNormal, AOVAlbedo:
+			layers = append(layers, layer(name, 3))
+		case AOVLights:
+			for _, light := range r.lightNames {
+				layers = append(layers, layer(light, 3))
+			}
+		}
+	}
+	return layers
+}
+
+// shade remplit le pixel index des couches ; beauty est la radiance déjà
+// calculée pour l'image finale.
+func (r aovRenderer) shade(layers []AOVLayer, index int, scene Scene, camera Camera, options RenderOptions, x, y, width, height int, beauty Vec3f) {
+	if len(layers) == 0 {
+		return
+	}
+	layers[0].set(index, beauty)
+
+	ray := camera.ray(x, y, width, height, camera.shutter.open)
+	hit, found := scene.closest(ray)
+	if found {
+		if m, ok := hit.material.(NormalMapped); ok {
+			hit.shadingNormal = m.perturb(hit)
+		}
+	}
+	k := 1
+	for _, name := range r.set {
+		switch name {
+		case AOVDepth:
+			depth := float32(math.Inf(1))
+			if found {
+				depth = Dot(Add(hit.position, camera.position.inverte()), camera.direction())
+			}
+			layers[k].set(index, Vec3f{depth, 0, 0})
+		case AOVNormal:
+			if found {
+				layers[k].set(index, hit.shadingNormal)
+			}
+		case AOVAlbedo:
+			if found {
+				layers[k].set(index, albedoOf(hit.material, hit))
+			}
+		case AOVObjectID:
+			if found {
+				layers[k].set(index, Vec3f{float32(hit.objectID), 0, 0})
+			}
+		case AOVMaterialID:
+			if found {
+				layers[k].set(index, Vec3f{float32(scene.materialID(hit.material)), 0, 0})
+			}
+		case AOVLights:
+			for _, s := range r.lightScenes {
+				layers[k].set(index, pixelRadiance(s, camera, options, x, y, width, height))
+				k++
+			}
+			continue
+		}
+		k++
+	}
+}
+
+// pasteLayers recopie les couches d'une tuile dans celles de l'image, en
+// les allouant à la première tuile reçue. Avec un poids positif, la tuile
+// est ajoutée, pondérée, à ce que d'autres jobs ont déjà rendu.
+func pasteLayers(dst []AOVLayer, src []AOVLayer, startX, startY, tileWidth, width, height int, weight float32) []AOVLayer {
+	if dst == nil {
+		for _, l := range src {
+			dst = append(dst, AOVLayer{l.Name, l.Channels, make([]float32, width*height*l.Channels)})
+		}
+	}
+	for i, l := range src {
+		if i >= len(dst) || dst[i].Name != l.Name {
+			continue
+		}
+		c := l.Channels
+		for j := 0; j < len(l.Data)/c; j++ {
+			x, y := startX+j%tileWidth, startY+j/tileWidth
+			if x >= width || y >= height {
+				continue
+			}
+			pixel := dst[i].Data[(y*width+x)*c : (y*width+x+1)*c]
+			if weight <= 0 {
+				copy(pixel, l.Data[j*c:(j+1)*c])
+				continue
+			}
+			for k := range pixel {
+				pixel[k] += l.Data[j*c+k] * weight
+			}
+		}
+	}
+	return dst
+}
+
+// ------------------------------
+// Albédo : couleur de base du matériau au point d'impact, sans éclairage.
+type albedoMaterial interface {
+	albedo(hit HitRecord) Vec3f
+}
+
+func albedoOf(m Materials, hit HitRecord) Vec3f {
+	if a, ok := m.(albedoMaterial); ok {
+		return a.albedo(hit)
+	}
+	return Vec3f{1, 1, 1}
+}
+
+func (p Phong) albedo(hit HitRecord) Vec3f        { return modulate(p.kd, p.kdMap, hit) }
+func (l Lambert) albedo(hit HitRecord) Vec3f      { return modulate(l.kd, l.kdMap, hit) }
+func (m CookTorrance) albedo(hit HitRecord) Vec3f { return m.params(hit).baseColor }
+func (e Emissive) albedo(hit HitRecord) Vec3f     { return e.color }
+func (m NormalMapped) albedo(hit HitRecord) Vec3f { return albedoOf(m.material, hit) }
+
+func (m TerrainMaterial) albedo(hit HitRecord) Vec3f {
+	return m.blend(hit, func(layer Materials) Vec3f { return albedoOf(layer, hit) })
+}
+
+// ------------------------------
+// Identifiants de matériau : les matériaux distincts des objets de la scène,
+// dans l'ordre des objets, pour que chaque client du rendu distribué
+// attribue les mêmes.
+
+func collectMaterials(object GeometricObject, table []Materials) []Materials {
+	add := func(m Materials) []Materials {
+		if m == nil {
+			return table
+		}
+		for _, known := range table {
+			if refl.DeepEqual(known, m) {
+				return table
+			}
+		}
+		return append(table, m)
+	}
+	switch obj := object.(type) {
+	case Transformed:
+		return collectMaterials(obj.object, table)
+	case Moving:
+		return collectMaterials(obj.object, table)
+	case CSG:
+		table = add(obj.Material)
+		table = collectMaterials(obj.left, table)
+		return collectMaterials(obj.right, table)
+	case materialHolder:
+		return add(obj.material())
+	}
+	return table
+}
+
+// materialID renvoie 1 + l'indice du matériau dans la table, 0 s'il est inconnu.
+func (s Scene) materialID(m Materials) int {
+	if m == nil {
+		return 0
+	}
+	for i, known := range s.materialTable {
+		if refl.DeepEqual(known, m) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// ------------------------------
+// Écriture des passes
+
+// saveAOVs écrit les passes à côté de l'image finale base.png : un fichier
+// base_<passe>.png par passe, ou toutes les couches (image finale comprise,
+// en flottants) dans base.exr.
+func saveAOVs(base string, layers []AOVLayer, width, height int, format string) error {
+	if len(layers) == 0 {
+		return nil
+	}
+	switch format {
+	case "exr":
+		return saveEXR(base+".exr", layers, width, height)
+	case "png":
+		for _, l := range layers {
+			if l.Name == beautyLayer {
+				continue
+			}
+			if err := saveLayerPNG(fmt.Sprintf("%s_%s.png", base, l.Name), l, width, height); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown AOV format %q", format)
+}
+
+// saveLayerPNG ramène une passe dans [0, 1] pour l'afficher : profondeur
+// normalisée (proche en clair), normales de [-1, 1], identifiants en
+// couleurs arbitraires.
+func saveLayerPNG(path string, l AOVLayer, width, height int) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	near, far := float32(math.Inf(1)), float32(0)
+	if l.Name == AOVDepth {
+		for _, d := range l.Data {
+			if !math.IsInf(float64(d), 0) {
+				near, far = min(near, d), max(far, d)
+			}
+		}
+	}
+	to8 := func(v float32) uint8 { return uint8(clamp32(v, 0, 1) * 255) }
+	for i := 0; i < width*height; i++ {
+		var c Vec3f
+		v := l.Data[i*l.Channels : (i+1)*l.Channels]
+		switch l.Name {
+		case AOVDepth:
+			if !math.IsInf(float64(v[0]), 0) {
+				g := 1 - (v[0]-near)/max(far-near, 1e-6)
+				c = Vec3f{g, g, g}
+			}
+		case AOVNormal:
+			c = Add(Vec3f{v[0], v[1], v[2]}.mul(0.5), Vec3f{0.5, 0.5, 0.5})
+		case AOVObjectID, AOVMaterialID:
+			c = idColor(int(v[0]))
+		default:
+			c = Vec3f{v[0], v[1], v[2]}
+		}
+		img.Set(i%width, i/width, color.RGBA{to8(c.x), to8(c.y), to8(c.z), 255})
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, img)
+}
+
+// idColor : couleur stable et contrastée pour un identifiant, noire pour 0.
+func idColor(id int) Vec3f {
+	if id == 0 {
+		return Vec3f{}
+	}
+	r := NewRng(uint64(id))
+	return Vec3f{0.2 + 0.8*r.float(), 0.2 + 0.8*r.float(), 0.2 + 0.8*r.float()}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ------------------------------
+// Emissive : surface lumineuse, qui émet color * strength du côté de sa
+// normale sortante. Les sphères, disques, rectangles et maillages émissifs
+// deviennent des sources surfaciques échantillonnées pour les ombres douces ;
+// les autres formes n'éclairent que par les chemins qui les touchent.
+// Un spectre d'émission, de luminance 1, sert au rendu spectral ; color est
+// alors sa couleur.
+type Emissive struct {
+	color    Vec3f
+	strength float32
+	spectrum Spectrum
+}
+
+func (e Emissive) radiance() Vec3f {
+	return e.color.mul(e.strength)
+}
+
+// emits : une surface émissive n'éclaire que du côté de sa normale. Les
+// deux path tracers suivent cette règle.
+func (e Emissive) emits(hit HitRecord) bool {
+	return hit.frontFace
+}
+
+func (e Emissive) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	if !e.emits(hit) {
+		return Vec3f{}
+	}
+	return e.radiance()
+}
+
+// ------------------------------
+// Surfaces échantillonnables uniformément selon l'aire : sampleSurface
+// renvoie un point, la normale sortante en ce point et la densité par unité
+// d'aire.
+type Emitter interface {
+	sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32)
+}
+
+func (s Sphere) sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32) {
+	n := uniformSphere(u1, u2)
+	return Add(s.position, n.mul(s.radius)), n, 1 / (4 * math.Pi * s.radius * s.radius)
+}
+
+func (d Disk) sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32) {
+	tu, tv := orthonormalBasis(d.normal)
+	r := d.radius * sqrt32(u1)
+	phi := 2 * math.Pi * float64(u2)
+	p := Add(d.center, Add(tu.mul(r*float32(math.Cos(phi))), tv.mul(r*float32(math.Sin(phi)))))
+	return p, d.normal, 1 / (math.Pi * d.radius * d.radius)
+}
+
+func (r Rectangle) sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32) {
+	n := cross(r.edgeU, r.edgeV)
+	p := Add(r.corner, Add(r.edgeU.mul(u1), r.edgeV.mul(u2)))
+	return p, n.normalized(), 1 / n.norme()
+}
+
+// meshEmitter choisit un triangle proportionnellement à son aire.
+type meshEmitter struct {
+	mesh *Mesh
+	cdf  []float32 // aires cumulées
+}
+
+func newMeshEmitter(mesh *Mesh) meshEmitter {
+	e := meshEmitter{mesh, make([]float32, len(mesh.triangles))}
+	total := float32(0)
+	for i, tri := range mesh.triangles {
+		v0, v1, v2 := mesh.vertices[tri[0]], mesh.vertices[tri[1]], mesh.vertices[tri[2]]
+		total += cross(Add(v1, v0.inverte()), Add(v2, v0.inverte())).norme() / 2
+		e.cdf[i] = total
+	}
+	return e
+}
+
+func (e meshEmitter) sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32) {
+	area := e.cdf[len(e.cdf)-1]
+	target := u1 * area
+	i := min(sort.Search(len(e.cdf), func(i int) bool { return e.cdf[i] > target }), len(e.cdf)-1)
+
+	// u1 est réutilisé à l'intérieur du triangle choisi
+	low := float32(0)
+	if i > 0 {
+		low = e.cdf[i-1]
+	}
+	u1 = clamp32((target-low)/(e.cdf[i]-low), 0, 1)
+
+	tri := e.mesh.triangles[i]
+	v0, v1, v2 := e.mesh.vertices[tri[0]], e.mesh.vertices[tri[1]], e.mesh.vertices[tri[2]]
+	su := sqrt32(u1)
+	b1, b2 := su*(1-u2), su*u2
+	p := Add(v0, Add(Add(v1, v0.inverte()).mul(b1), Add(v2, v0.inverte()).mul(b2)))
+	n := cross(Add(v1, v0.inverte()), Add(v2, v0.inverte())).normalized()
+	return p, n, 1 / area
+}
+
+// transformedEmitter échantillonne dans le repère de l'objet. L'aire est
+// dilatée de |det M| · |M^-T n| par la transformation M.
+type transformedEmitter struct {
+	emitter           Emitter
+	toWorld, toObject Matrix4
+}
+
+func (e transformedEmitter) sampleSurface(u1, u2 float32) (Vec3f, Vec3f, float32) {
+	p, n, pdf := e.emitter.sampleSurface(u1, u2)
+	worldNormal := e.toObject.transformNormal(n)
+	scale := abs32(e.toWorld.det3()) * worldNormal.norme()
+	return e.toWorld.transformPoint(p), worldNormal.normalized(), pdf / scale
+}
+
+// ------------------------------
+// AreaLight : surface émissive de la scène, trouvée par Scene.prepared.
+type AreaLight struct {
+	emitter  Emitter
+	emission Vec3f
+	spectrum Spectrum
+}
+
+func (a AreaLight) sampleCount(n int) int {
+	return n
+}
+
+// sampleFrom convertit la densité par unité d'aire en densité par angle
+// solide ; seule la face avant de la surface émet.
+func (a AreaLight) sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool) {
+	q, normal, pdf := a.emitter.sampleSurface(u1, u2)
+	d := Add(q, p.inverte())
+	dist2 := Dot(d, d)
+	wi := d.normalized()
+	cosL := -Dot(normal, wi)
+	if cosL <= 0 || pdf <= 0 || dist2 == 0 {
+		return Vec3f{}, Vec3f{}, 0, false
+	}
+	return wi, a.emission.mul(cosL / (dist2 * pdf)), sqrt32(dist2), true
+}
+
+// areaLightOf renvoie la source surfacique correspondant à un objet émissif.
+func areaLightOf(object GeometricObject) (AreaLight, bool) {
+	switch obj := object.(type) {
+	case Transformed:
+		light, ok := areaLightOf(obj.object)
+		if ok {
+			light.emitter = transformedEmitter{light.emitter, obj.toWorld, obj.toObject}
+		}
+		return light, ok
+	case *Mesh:
+		e, ok := obj.Material.(Emissive)
+		if !ok || len(obj.triangles) == 0 {
+			return AreaLight{}, false
+		}
+		return AreaLight{newMeshEmitter(obj), e.radiance(), e.spectrum}, true
+	}
+	emitter, isEmitter := object.(Emitter)
+	holder, hasMaterial := object.(materialHolder)
+	if !isEmitter || !hasMaterial {
+		return AreaLight{}, false
+	}
+	e, ok := holder.material().(Emissive)
+	return AreaLight{emitter, e.radiance(), e.spectrum}, ok
+}
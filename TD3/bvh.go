@@ -0,0 +1,258 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ------------------------------
+// Boîte englobante alignée sur les axes
+type Bounds struct {
+	min, max Vec3f
+}
+
+func emptyBounds() Bounds {
+	inf := float32(math.Inf(1))
+	return Bounds{Vec3f{inf, inf, inf}, Vec3f{-inf, -inf, -inf}}
+}
+
+// Les objets infinis (plans...) renvoient infiniteBounds et restent hors de la BVH.
+func infiniteBounds() Bounds {
+	inf := float32(math.Inf(1))
+	return Bounds{Vec3f{-inf, -inf, -inf}, Vec3f{inf, inf, inf}}
+}
+
+func (b Bounds) infinite() bool {
+	return math.IsInf(float64(b.max.x-b.min.x), 0) ||
+		math.IsInf(float64(b.max.y-b.min.y), 0) ||
+		math.IsInf(float64(b.max.z-b.min.z), 0)
+}
+
+func (b Bounds) addPoint(p Vec3f) Bounds {
+	return Bounds{
+		Vec3f{min(b.min.x, p.x), min(b.min.y, p.y), min(b.min.z, p.z)},
+		Vec3f{max(b.max.x, p.x), max(b.max.y, p.y), max(b.max.z, p.z)},
+	}
+}
+
+func (b Bounds) union(o Bounds) Bounds {
+	return b.addPoint(o.min).addPoint(o.max)
+}
+
+func (b Bounds) translated(v Vec3f) Bounds {
+	return Bounds{Add(b.min, v), Add(b.max, v)}
+}
+
+func (b Bounds) centroid() Vec3f {
+	return Add(b.min, b.max).mul(0.5)
+}
+
+// transformed englobe les huit coins de la boîte transformée.
+func (b Bounds) transformed(m Matrix4) Bounds {
+	if b.infinite() {
+		return b
+	}
+	res := emptyBounds()
+	for i := 0; i < 8; i++ {
+		corner := b.min
+		if i&1 != 0 {
+			corner.x = b.max.x
+		}
+		if i&2 != 0 {
+			corner.y = b.max.y
+		}
+		if i&4 != 0 {
+			corner.z = b.max.z
+		}
+		res = res.addPoint(m.transformPoint(corner))
+	}
+	return res
+}
+
+func axis(v Vec3f, a int) float32 {
+	switch a {
+	case 0:
+		return v.x
+	case 1:
+		return v.y
+	}
+	return v.z
+}
+
+// hit : méthode des "slabs". Renvoie la distance d'entrée dans la boîte.
+func (b Bounds) hit(ray Ray, tmax float32) (bool, float32) {
+	tmin := float32(0)
+	for a := 0; a < 3; a++ {
+		invD := 1 / axis(ray.direction, a)
+		t0 := (axis(b.min, a) - axis(ray.origin, a)) * invD
+		t1 := (axis(b.max, a) - axis(ray.origin, a)) * invD
+		if invD < 0 {
+			t0, t1 = t1, t0
+		}
+		tmin = max(tmin, t0)
+		tmax = min(tmax, t1)
+		if tmax < tmin {
+			return false, 0
+		}
+	}
+	return true, tmin
+}
+
+// ------------------------------
+// closestHit parcourt linéairement une liste d'objets.
+func closestHit(objects []GeometricObject, ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	hit, _, found := closestIndex(objects, ray, tmin, tmax)
+	return hit, found
+}
+
+// closestIndex renvoie aussi l'indice de l'objet touché.
+func closestIndex(objects []GeometricObject, ray Ray, tmin, tmax float32) (HitRecord, int, bool) {
+	var closest HitRecord
+	index := -1
+	for i, object := range objects {
+		if hit, ok := object.intersect(ray, tmin, tmax); ok {
+			closest, tmax, index = hit, hit.t, i
+		}
+	}
+	return closest, index, index >= 0
+}
+
+type bvhNode struct {
+	bounds       Bounds
+	left, right  int // -1 pour une feuille
+	first, count int // objets de la feuille
+}
+
+// Bounding Volume Hierarchy : arbre binaire de boîtes englobantes, découpé à
+// la médiane de l'axe le plus long. Les impacts portent l'identifiant de
+// l'objet touché : son rang dans la liste donnée à NewBVH, plus un.
+type BVH struct {
+	nodes             []bvhNode
+	objects           []GeometricObject
+	unbounded         []GeometricObject
+	ids, unboundedIDs []int
+}
+
+const bvhLeafSize = 4
+
+func NewBVH(objects []GeometricObject) *BVH {
+	bvh := &BVH{}
+	var bounds []Bounds
+	for i, object := range objects {
+		b := object.bounds()
+		if b.infinite() {
+			bvh.unbounded = append(bvh.unbounded, object)
+			bvh.unboundedIDs = append(bvh.unboundedIDs, i+1)
+			continue
+		}
+		bvh.objects = append(bvh.objects, object)
+		bvh.ids = append(bvh.ids, i+1)
+		bounds = append(bounds, b)
+	}
+	if len(bvh.objects) > 0 {
+		bvh.build(bounds, 0, len(bvh.objects))
+	}
+	return bvh
+}
+
+func (bvh *BVH) build(bounds []Bounds, first, count int) int {
+	index := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, bvhNode{left: -1, right: -1, first: first, count: count})
+
+	nodeBounds := emptyBounds()
+	centroids := emptyBounds()
+	for i := first; i < first+count; i++ {
+		nodeBounds = nodeBounds.union(bounds[i])
+		centroids = centroids.addPoint(bounds[i].centroid())
+	}
+	bvh.nodes[index].bounds = nodeBounds
+	if count <= bvhLeafSize {
+		return index
+	}
+
+	extent := Add(centroids.max, centroids.min.inverte())
+	split := 0
+	if extent.y > extent.x && extent.y >= extent.z {
+		split = 1
+	} else if extent.z > extent.x && extent.z > extent.y {
+		split = 2
+	}
+
+	sort.Sort(byCentroid{bvh.objects[first : first+count], bvh.ids[first : first+count], bounds[first : first+count], split})
+	half := count / 2
+	left := bvh.build(bounds, first, half)
+	right := bvh.build(bounds, first+half, count-half)
+	bvh.nodes[index].left, bvh.nodes[index].right = left, right
+	return index
+}
+
+type byCentroid struct {
+	objects []GeometricObject
+	ids     []int
+	bounds  []Bounds
+	axis    int
+}
+
+func (s byCentroid) Len() int { return len(s.objects) }
+func (s byCentroid) Less(i, j int) bool {
+	return axis(s.bounds[i].centroid(), s.axis) < axis(s.bounds[j].centroid(), s.axis)
+}
+func (s byCentroid) Swap(i, j int) {
+	s.objects[i], s.objects[j] = s.objects[j], s.objects[i]
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+	s.bounds[i], s.bounds[j] = s.bounds[j], s.bounds[i]
+}
+
+func (bvh *BVH) closest(ray Ray) (HitRecord, bool) {
+	return bvh.traverse(ray, nil)
+}
+
+// traversalStats compte le travail d'une traversée : nœuds visités et tests
+// d'intersection avec les objets.
+type traversalStats struct {
+	nodes, tests int
+}
+
+// traverse cherche l'impact le plus proche en comptant le travail dans
+// stats, s'il n'est pas nil.
+func (bvh *BVH) traverse(ray Ray, stats *traversalStats) (HitRecord, bool) {
+	if stats != nil {
+		stats.tests += len(bvh.unbounded)
+	}
+	closest, index, found := closestIndex(bvh.unbounded, ray, hitEpsilon, float32(math.MaxFloat32))
+	if found {
+		closest.objectID = bvh.unboundedIDs[index]
+	}
+	if len(bvh.nodes) == 0 {
+		return closest, found
+	}
+	tmax := float32(math.MaxFloat32)
+	if found {
+		tmax = closest.t
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if stats != nil {
+			stats.nodes++
+		}
+
+		if ok, _ := node.bounds.hit(ray, tmax); !ok {
+			continue
+		}
+		if node.left < 0 {
+			if stats != nil {
+				stats.tests += node.count
+			}
+			if hit, i, ok := closestIndex(bvh.objects[node.first:node.first+node.count], ray, hitEpsilon, tmax); ok {
+				hit.objectID = bvh.ids[node.first+i]
+				closest, tmax, found = hit, hit.t, true
+			}
+			continue
+		}
+		stack = append(stack, node.left, node.right)
+	}
+	return closest, found
+}
@@ -0,0 +1,107 @@
+package main
+
+// ------------------------------
+// Géométrie de construction de solides (CSG). Les opérandes doivent être
+// fermés : chaque impact est soit une entrée (frontFace), soit une sortie.
+// Un plan se comporte comme un demi-espace, du côté opposé à sa normale.
+type CSGOperation int
+
+const (
+	CSGUnion CSGOperation = iota
+	CSGIntersection
+	CSGDifference
+)
+
+type CSG struct {
+	operation   CSGOperation
+	left, right GeometricObject
+	// Material, s'il est défini, remplace celui des opérandes
+	Material Materials
+}
+
+func Union(a, b GeometricObject) CSG        { return CSG{CSGUnion, a, b, nil} }
+func Intersection(a, b GeometricObject) CSG { return CSG{CSGIntersection, a, b, nil} }
+func Difference(a, b GeometricObject) CSG   { return CSG{CSGDifference, a, b, nil} }
+
+func (c CSG) inside(inLeft, inRight bool) bool {
+	switch c.operation {
+	case CSGIntersection:
+		return inLeft && inRight
+	case CSGDifference:
+		return inLeft && !inRight
+	}
+	return inLeft || inRight
+}
+
+// intersect avance le long du rayon d'une surface d'opérande à l'autre, et
+// s'arrête à la première qui fait changer l'état intérieur/extérieur du solide.
+func (c CSG) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	hitL, okL := c.left.intersect(ray, tmin, tmax)
+	hitR, okR := c.right.intersect(ray, tmin, tmax)
+	// Si la première surface rencontrée est une sortie, le rayon part de l'intérieur
+	inLeft := okL && !hitL.frontFace
+	inRight := okR && !hitR.frontFace
+	wasInside := c.inside(inLeft, inRight)
+
+	for okL || okR {
+		var hit HitRecord
+		if okL && (!okR || hitL.t <= hitR.t) {
+			hit = hitL
+			inLeft = hitL.frontFace
+			hitL, okL = c.left.intersect(ray, hitL.t+hitEpsilon, tmax)
+		} else {
+			hit = hitR
+			inRight = hitR.frontFace
+			hitR, okR = c.right.intersect(ray, hitR.t+hitEpsilon, tmax)
+		}
+
+		isInside := c.inside(inLeft, inRight)
+		if isInside == wasInside {
+			continue
+		}
+
+		// La normale sortante du solide s'oppose au rayon quand on y entre
+		outward := hit.geometricNormal
+		if (Dot(outward, ray.direction) < 0) != isInside {
+			outward = outward.inverte()
+		}
+		material := hit.material
+		if c.Material != nil {
+			material = c.Material
+		}
+		res := newHit(ray, hit.t, outward, hit.uv, material)
+		res.tangent = hit.tangent
+		return res, true
+	}
+	return HitRecord{}, false
+}
+
+func (c CSG) bounds() Bounds {
+	l, r := c.left.bounds(), c.right.bounds()
+	switch c.operation {
+	case CSGIntersection:
+		if l.infinite() {
+			return r
+		}
+		if r.infinite() {
+			return l
+		}
+		return Bounds{
+			Vec3f{max(l.min.x, r.min.x), max(l.min.y, r.min.y), max(l.min.z, r.min.z)},
+			Vec3f{min(l.max.x, r.max.x), min(l.max.y, r.max.y), min(l.max.z, r.max.z)},
+		}
+	case CSGDifference:
+		return l
+	}
+	return l.union(r)
+}
+
+func (c CSG) material() Materials {
+	return c.Material
+}
+
+func (c CSG) withMaterial(m Materials) GeometricObject {
+	c.left = inheritMaterial(c.left, m)
+	c.right = inheritMaterial(c.right, m)
+	return c
+}
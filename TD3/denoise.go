@@ -0,0 +1,140 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Débruiteur en ondelettes à trous avec arrêt aux contours (Dammertz et al.
+// 2010) : un noyau B-spline 5×5 dont les trous doublent à chaque passe,
+// pondéré par la ressemblance des normales, des profondeurs et des
+// couleurs. L'éclairement est filtré seul, albédo retiré, pour garder nettes
+// les textures. strength règle la tolérance sur les couleurs : 1 convient à
+// quelques échantillons par pixel, plus lisse davantage.
+
+const denoiseIterations = 5
+
+// Tolérances des guides : écart de cosinus des normales, écart relatif des
+// profondeurs, et écart de couleur pour strength = 1
+const (
+	denoiseSigmaNormal = 0.1
+	denoiseSigmaDepth  = 0.05
+	denoiseSigmaColor  = 0.6
+)
+
+var atrousKernel = [5]float32{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// denoise renvoie l'image finale débruitée d'après les couches beauty,
+// albedo, normal et depth de l'image entière.
+func denoise(layers []AOVLayer, width, height int, strength float32) []Vec3f {
+	find := func(name string) AOVLayer {
+		for _, l := range layers {
+			if l.Name == name {
+				return l
+			}
+		}
+		return AOVLayer{}
+	}
+	beauty, albedoLayer, normalLayer, depthLayer := find(beautyLayer), find(AOVAlbedo), find(AOVNormal), find(AOVDepth)
+	n := width * height
+	vec := func(l AOVLayer, i int) Vec3f {
+		return Vec3f{l.Data[3*i], l.Data[3*i+1], l.Data[3*i+2]}
+	}
+
+	// Les pixels de fond (profondeur infinie) ne se mêlent qu'entre eux
+	background := make([]bool, n)
+	albedo := make([]Vec3f, n)
+	current := make([]Vec3f, n)
+	for i := 0; i < n; i++ {
+		background[i] = math.IsInf(float64(depthLayer.Data[i]), 0)
+		albedo[i] = Vec3f{1, 1, 1}
+		if !background[i] {
+			a := vec(albedoLayer, i)
+			albedo[i] = Vec3f{max(a.x, 1e-3), max(a.y, 1e-3), max(a.z, 1e-3)}
+		}
+		c := vec(beauty, i)
+		current[i] = Vec3f{c.x / albedo[i].x, c.y / albedo[i].y, c.z / albedo[i].z}
+	}
+
+	suppressFireflies(current, background, width, height)
+
+	next := make([]Vec3f, n)
+	sigmaColor := denoiseSigmaColor * strength
+	for it := 0; it < denoiseIterations; it++ {
+		step := 1 << it
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p := y*width + x
+				cp := current[p]
+				np := vec(normalLayer, p)
+				zp := depthLayer.Data[p]
+				// Tolérance relative à la luminance, pour les hautes lumières
+				colorScale := sigmaColor * sigmaColor * (1 + luminance(cp))
+
+				var sum Vec3f
+				weights := float32(0)
+				for dy := -2; dy <= 2; dy++ {
+					qy := y + dy*step
+					if qy < 0 || qy >= height {
+						continue
+					}
+					for dx := -2; dx <= 2; dx++ {
+						qx := x + dx*step
+						if qx < 0 || qx >= width {
+							continue
+						}
+						q := qy*width + qx
+						if background[p] != background[q] {
+							continue
+						}
+						w := atrousKernel[dx+2] * atrousKernel[dy+2]
+						if !background[p] {
+							w *= float32(math.Exp(float64(-max(0, 1-Dot(np, vec(normalLayer, q))) / denoiseSigmaNormal)))
+							w *= float32(math.Exp(float64(-abs32(zp-depthLayer.Data[q]) / (denoiseSigmaDepth * zp * float32(step)))))
+						}
+						d := Add(cp, current[q].inverte())
+						w *= float32(math.Exp(float64(-Dot(d, d) / colorScale)))
+						sum = Add(sum, current[q].mul(w))
+						weights += w
+					}
+				}
+				next[p] = sum.mul(1 / weights)
+			}
+		}
+		current, next = next, current
+		// Les passes suivantes, plus larges, tolèrent moins d'écart
+		sigmaColor *= 0.5
+	}
+
+	for i := range current {
+		current[i] = Mul(current[i], albedo[i])
+	}
+	return current
+}
+
+// suppressFireflies ramène chaque pixel isolé bien plus lumineux que tous ses
+// voisins à fireflyRatio fois le plus lumineux d'entre eux : le filtre les
+// écarterait comme des contours.
+const fireflyRatio = 2
+
+func suppressFireflies(pixels []Vec3f, background []bool, width, height int) {
+	source := append([]Vec3f(nil), pixels...)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := y*width + x
+			brightest := float32(0)
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					qx, qy := x+dx, y+dy
+					if (dx == 0 && dy == 0) || qx < 0 || qx >= width || qy < 0 || qy >= height {
+						continue
+					}
+					if q := qy*width + qx; background[q] == background[p] {
+						brightest = max(brightest, luminance(source[q]))
+					}
+				}
+			}
+			if l := luminance(source[p]); l > fireflyRatio*brightest && l > 0 {
+				pixels[p] = source[p].mul(fireflyRatio * brightest / l)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+// ------------------------------
+// Dielectric : verre ou eau lisse d'indice ior, teinté par tint à chaque
+// traversée. Réflexion et réfraction sont parfaites (BSDF de Dirac) : le
+// tracé de chemins choisit l'une ou l'autre selon le facteur de Fresnel, le
+// rendu direct suit les deux. Un nombre d'Abbe abbe non nul rend le verre
+// dispersif pour le rendu spectral : ior est alors l'indice à 587,6 nm.
+type Dielectric struct {
+	ior  float32
+	tint Vec3f
+	abbe float32
+}
+
+func NewDielectric(ior float32, tint Vec3f) Dielectric {
+	return Dielectric{ior: ior, tint: tint}
+}
+
+// iorAt renvoie l'indice à une longueur d'onde en nanomètres, selon la loi
+// de Cauchy n = A + B / λ² ajustée sur l'indice et le nombre d'Abbe (raies
+// d, F et C de Fraunhofer).
+func (d Dielectric) iorAt(lambda float32) float32 {
+	if d.abbe <= 0 {
+		return d.ior
+	}
+	const lambdaD, lambdaF, lambdaC = 0.5876, 0.4861, 0.6563 // µm
+	b := (d.ior - 1) / (d.abbe * (1/(lambdaF*lambdaF) - 1/(lambdaC*lambdaC)))
+	a := d.ior - b/(lambdaD*lambdaD)
+	l := lambda / 1000
+	return a + b/(l*l)
+}
+
+// deltaBSDF : BSDF de Dirac, que ni eval ni l'échantillonnage des sources
+// ne peuvent atteindre.
+type deltaBSDF interface {
+	delta() bool
+}
+
+func isDelta(b BSDF) bool {
+	d, ok := b.(deltaBSDF)
+	return ok && d.delta()
+}
+
+func (d Dielectric) delta() bool { return true }
+
+func (d Dielectric) eval(hit HitRecord, wo, wi Vec3f) Vec3f  { return Vec3f{} }
+func (d Dielectric) pdf(hit HitRecord, wo, wi Vec3f) float32 { return 0 }
+
+// fresnelDielectric renvoie la part réfléchie pour un cosinus d'incidence
+// cosI et un rapport d'indices eta (transmis sur incident), ainsi que le
+// cosinus de l'angle de réfraction, nul en réflexion totale.
+func fresnelDielectric(cosI, eta float32) (float32, float32) {
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return 1, 0
+	}
+	cosT := sqrt32(1 - sin2T)
+	parallel := (eta*cosI - cosT) / (eta*cosI + cosT)
+	perpendicular := (cosI - eta*cosT) / (cosI + eta*cosT)
+	return (parallel*parallel + perpendicular*perpendicular) / 2, cosT
+}
+
+// split renvoie les directions réfléchie et réfractée depuis wo, la part
+// réfléchie et l'existence d'une réfraction.
+func (d Dielectric) split(hit HitRecord, wo Vec3f) (Vec3f, Vec3f, float32, bool) {
+	return d.splitIOR(hit, wo, d.ior)
+}
+
+func (d Dielectric) splitIOR(hit HitRecord, wo Vec3f, ior float32) (Vec3f, Vec3f, float32, bool) {
+	n := hit.shadingNormal
+	eta := ior
+	if !hit.frontFace {
+		eta = 1 / ior
+	}
+	cosI := max(Dot(n, wo), 0)
+	r := reflect(wo.inverte(), n)
+	F, cosT := fresnelDielectric(cosI, eta)
+	if F >= 1 {
+		return r, Vec3f{}, 1, false
+	}
+	t := Add(wo.inverte().mul(1/eta), n.mul(cosI/eta-cosT)).normalized()
+	return r, t, F, true
+}
+
+func (d Dielectric) sample(hit HitRecord, wo Vec3f, rng *Rng) (Vec3f, Vec3f, bool) {
+	r, t, F, refracts := d.split(hit, wo)
+	if !refracts || rng.float() < F {
+		return r, Vec3f{1, 1, 1}, true
+	}
+	return t, d.tint, true
+}
+
+func (d Dielectric) albedo(hit HitRecord) Vec3f { return d.tint }
+
+// Profondeur des rebonds suivis par le rendu direct
+const dielectricMaxDepth = 6
+
+func (d Dielectric) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	return d.trace(ray, hit, scene, 0)
+}
+
+func (d Dielectric) trace(ray Ray, hit HitRecord, scene Scene, depth int) Vec3f {
+	wo := ray.direction.inverte().normalized()
+	r, t, F, refracts := d.split(hit, wo)
+	follow := func(direction Vec3f) Vec3f {
+		next := Ray{hit.position, direction, ray.time}
+		h, found := scene.closest(next)
+		switch {
+		case !found:
+			return scene.background(next)
+		case h.material == nil:
+			return Vec3f{}
+		}
+		if glass, ok := h.material.(Dielectric); ok {
+			if depth+1 >= dielectricMaxDepth {
+				return Vec3f{}
+			}
+			return glass.trace(next, h, scene, depth+1)
+		}
+		return h.material.render(next, h, scene)
+	}
+	res := follow(r).mul(F)
+	if refracts {
+		res = Add(res, Mul(d.tint, follow(t)).mul(1-F))
+	}
+	return res
+}
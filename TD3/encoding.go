@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	rf "reflect"
+	"slices"
+	"unsafe"
+)
+
+// ------------------------------
+// Transport des jobs : gob ne transmet que les champs exportés, alors que
+// les types du rendu gardent leurs champs privés. Chacun implémente donc
+// GobEncoder et GobDecoder en envoyant tous ses champs, dans l'ordre de la
+// déclaration, avec encodeFields et decodeFields. Les champs calculés par
+// Scene.prepared ou par la préparation d'un intégrateur sont omis et
+// reconstruits par le client.
+//
+// gob ne suit pas le partage des pointeurs : la scène envoie donc une table
+// de ses maillages distincts, et chaque instance n'en transmet que le rang.
+// Le client rétablit un seul *Mesh par entrée de la table.
+
+// encodeFields encode les champs de la structure pointée par v, sauf ceux
+// nommés dans skip. Un pointeur ou une interface nil est précédé d'un
+// drapeau de présence.
+func encodeFields(v any, skip ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	s := rf.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		name := s.Type().Field(i).Name
+		if slices.Contains(skip, name) {
+			continue
+		}
+		f := field(s, i)
+		if nullable(f) {
+			if err := encoder.Encode(!f.IsNil()); err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", s.Type().Name(), name, err)
+			}
+			if f.IsNil() {
+				continue
+			}
+		}
+		if err := encoder.EncodeValue(f); err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", s.Type().Name(), name, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeFields(data []byte, v any, skip ...string) error {
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	s := rf.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		name := s.Type().Field(i).Name
+		if slices.Contains(skip, name) {
+			continue
+		}
+		f := field(s, i)
+		if nullable(f) {
+			var present bool
+			if err := decoder.Decode(&present); err != nil {
+				return fmt.Errorf("%s.%s: %v", s.Type().Name(), name, err)
+			}
+			if !present {
+				continue
+			}
+		}
+		if err := decoder.DecodeValue(f.Addr()); err != nil {
+			return fmt.Errorf("%s.%s: %v", s.Type().Name(), name, err)
+		}
+	}
+	return nil
+}
+
+// field rend accessible en lecture et en écriture un champ privé.
+func field(s rf.Value, i int) rf.Value {
+	f := s.Field(i)
+	return rf.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+func nullable(f rf.Value) bool {
+	return f.Kind() == rf.Pointer || f.Kind() == rf.Interface
+}
+
+// Les vecteurs et les pixels, très nombreux, ont un format binaire fixe.
+func (v Vec3f) GobEncode() ([]byte, error) {
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b[0:], math.Float32bits(v.x))
+	binary.LittleEndian.PutUint32(b[4:], math.Float32bits(v.y))
+	binary.LittleEndian.PutUint32(b[8:], math.Float32bits(v.z))
+	return b, nil
+}
+
+func (v *Vec3f) GobDecode(b []byte) error {
+	if len(b) != 12 {
+		return fmt.Errorf("Vec3f: %d bytes", len(b))
+	}
+	v.x = math.Float32frombits(binary.LittleEndian.Uint32(b[0:]))
+	v.y = math.Float32frombits(binary.LittleEndian.Uint32(b[4:]))
+	v.z = math.Float32frombits(binary.LittleEndian.Uint32(b[8:]))
+	return nil
+}
+
+func (c rgbRepresentation) GobEncode() ([]byte, error) { return []byte{c.r, c.g, c.b}, nil }
+
+func (c *rgbRepresentation) GobDecode(b []byte) error {
+	if len(b) != 3 {
+		return fmt.Errorf("rgbRepresentation: %d bytes", len(b))
+	}
+	c.r, c.g, c.b = b[0], b[1], b[2]
+	return nil
+}
+
+// Scène et caméra. La BVH, les sources surfaciques et la table des
+// matériaux sont recalculées par prepared.
+var sceneDerived = []string{"areaLights", "sampled", "accel", "materialTable"}
+
+func (s Scene) GobEncode() ([]byte, error) {
+	var meshes []*Mesh
+	ranks := map[*Mesh]meshRank{}
+	s = s.mapObjects(func(object GeometricObject) GeometricObject {
+		mesh, ok := object.(*Mesh)
+		if !ok {
+			return object
+		}
+		rank, seen := ranks[mesh]
+		if !seen {
+			rank = meshRank(len(meshes))
+			ranks[mesh] = rank
+			meshes = append(meshes, mesh)
+		}
+		return rank
+	})
+	fields, err := encodeFields(&s, sceneDerived...)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	if err := encoder.Encode(meshes); err != nil {
+		return nil, fmt.Errorf("Scene meshes: %v", err)
+	}
+	if err := encoder.Encode(fields); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Scene) GobDecode(b []byte) error {
+	var meshes []*Mesh
+	var fields []byte
+	decoder := gob.NewDecoder(bytes.NewReader(b))
+	if err := decoder.Decode(&meshes); err != nil {
+		return fmt.Errorf("Scene meshes: %v", err)
+	}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+	if err := decodeFields(fields, s, sceneDerived...); err != nil {
+		return err
+	}
+	var err error
+	*s = s.mapObjects(func(object GeometricObject) GeometricObject {
+		rank, ok := object.(meshRank)
+		if !ok {
+			return object
+		}
+		if int(rank) >= len(meshes) {
+			err = fmt.Errorf("Scene: mesh %d of %d", rank, len(meshes))
+			return object
+		}
+		return meshes[rank]
+	})
+	return err
+}
+
+// meshRank tient la place d'un maillage partagé pendant le transport : le
+// rang du maillage dans la table envoyée avec la scène.
+type meshRank int
+
+func (meshRank) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) { return HitRecord{}, false }
+func (meshRank) bounds() Bounds                                          { return emptyBounds() }
+
+// mapObjects renvoie une copie de la scène où f a remplacé chaque objet
+// élémentaire, y compris ceux des nœuds et des objets composés. La scène
+// d'origine n'est pas modifiée.
+func (s Scene) mapObjects(f func(GeometricObject) GeometricObject) Scene {
+	objects := make([]GeometricObject, len(s.objects))
+	for i, object := range s.objects {
+		objects[i] = mapObject(object, f)
+	}
+	s.objects = objects
+	if s.root != nil {
+		s.root = s.root.clone()
+		mapNode(s.root, f)
+	}
+	return s
+}
+
+func mapNode(n *Node, f func(GeometricObject) GeometricObject) {
+	if n.object != nil {
+		n.object = mapObject(n.object, f)
+	}
+	for _, child := range n.children {
+		mapNode(child, f)
+	}
+}
+
+func mapObject(object GeometricObject, f func(GeometricObject) GeometricObject) GeometricObject {
+	switch o := object.(type) {
+	case Transformed:
+		o.object = mapObject(o.object, f)
+		return o
+	case Moving:
+		o.object = mapObject(o.object, f)
+		return o
+	case CSG:
+		o.left, o.right = mapObject(o.left, f), mapObject(o.right, f)
+		return o
+	}
+	return f(object)
+}
+
+func (c Camera) GobEncode() ([]byte, error) { return encodeFields(&c) }
+func (c *Camera) GobDecode(b []byte) error  { return decodeFields(b, c) }
+func (s Shutter) GobEncode() ([]byte, error) {
+	return encodeFields(&s)
+}
+func (s *Shutter) GobDecode(b []byte) error { return decodeFields(b, s) }
+
+// Le parent d'un nœud n'est pas envoyé : il est rétabli depuis ses enfants.
+func (n Node) GobEncode() ([]byte, error) { return encodeFields(&n, "parent") }
+
+func (n *Node) GobDecode(b []byte) error {
+	if err := decodeFields(b, n, "parent"); err != nil {
+		return err
+	}
+	for _, child := range n.children {
+		child.parent = n
+	}
+	return nil
+}
+
+// Géométrie
+func (b Bounds) GobEncode() ([]byte, error)       { return encodeFields(&b) }
+func (b *Bounds) GobDecode(d []byte) error        { return decodeFields(d, b) }
+func (s Sphere) GobEncode() ([]byte, error)       { return encodeFields(&s) }
+func (s *Sphere) GobDecode(b []byte) error        { return decodeFields(b, s) }
+func (p Plane) GobEncode() ([]byte, error)        { return encodeFields(&p) }
+func (p *Plane) GobDecode(b []byte) error         { return decodeFields(b, p) }
+func (d Disk) GobEncode() ([]byte, error)         { return encodeFields(&d) }
+func (d *Disk) GobDecode(b []byte) error          { return decodeFields(b, d) }
+func (r Rectangle) GobEncode() ([]byte, error)    { return encodeFields(&r) }
+func (r *Rectangle) GobDecode(b []byte) error     { return decodeFields(b, r) }
+func (b Box) GobEncode() ([]byte, error)          { return encodeFields(&b) }
+func (b *Box) GobDecode(d []byte) error           { return decodeFields(d, b) }
+func (o OrientedBox) GobEncode() ([]byte, error)  { return encodeFields(&o) }
+func (o *OrientedBox) GobDecode(b []byte) error   { return decodeFields(b, o) }
+func (c Cylinder) GobEncode() ([]byte, error)     { return encodeFields(&c) }
+func (c *Cylinder) GobDecode(b []byte) error      { return decodeFields(b, c) }
+func (c Cone) GobEncode() ([]byte, error)         { return encodeFields(&c) }
+func (c *Cone) GobDecode(b []byte) error          { return decodeFields(b, c) }
+func (t Torus) GobEncode() ([]byte, error)        { return encodeFields(&t) }
+func (t *Torus) GobDecode(b []byte) error         { return decodeFields(b, t) }
+func (m Mesh) GobEncode() ([]byte, error)         { return encodeFields(&m) }
+func (m *Mesh) GobDecode(b []byte) error          { return decodeFields(b, m) }
+func (t Transformed) GobEncode() ([]byte, error)  { return encodeFields(&t) }
+func (t *Transformed) GobDecode(b []byte) error   { return decodeFields(b, t) }
+func (m Moving) GobEncode() ([]byte, error)       { return encodeFields(&m) }
+func (m *Moving) GobDecode(b []byte) error        { return decodeFields(b, m) }
+func (c CSG) GobEncode() ([]byte, error)          { return encodeFields(&c) }
+func (c *CSG) GobDecode(b []byte) error           { return decodeFields(b, c) }
+func (h Heightfield) GobEncode() ([]byte, error)  { return encodeFields(&h) }
+func (h *Heightfield) GobDecode(b []byte) error   { return decodeFields(b, h) }
+func (o SDFObject) GobEncode() ([]byte, error)    { return encodeFields(&o) }
+func (o *SDFObject) GobDecode(b []byte) error     { return decodeFields(b, o) }
+func (s SDFSphere) GobEncode() ([]byte, error)    { return encodeFields(&s) }
+func (s *SDFSphere) GobDecode(b []byte) error     { return decodeFields(b, s) }
+func (s SDFBox) GobEncode() ([]byte, error)       { return encodeFields(&s) }
+func (s *SDFBox) GobDecode(b []byte) error        { return decodeFields(b, s) }
+func (s SDFRoundBox) GobEncode() ([]byte, error)  { return encodeFields(&s) }
+func (s *SDFRoundBox) GobDecode(b []byte) error   { return decodeFields(b, s) }
+func (s SDFTorus) GobEncode() ([]byte, error)     { return encodeFields(&s) }
+func (s *SDFTorus) GobDecode(b []byte) error      { return decodeFields(b, s) }
+func (s SDFCapsule) GobEncode() ([]byte, error)   { return encodeFields(&s) }
+func (s *SDFCapsule) GobDecode(b []byte) error    { return decodeFields(b, s) }
+func (s SmoothSDF) GobEncode() ([]byte, error)    { return encodeFields(&s) }
+func (s *SmoothSDF) GobDecode(b []byte) error     { return decodeFields(b, s) }
+func (r Repeat) GobEncode() ([]byte, error)       { return encodeFields(&r) }
+func (r *Repeat) GobDecode(b []byte) error        { return decodeFields(b, r) }
+func (t Twist) GobEncode() ([]byte, error)        { return encodeFields(&t) }
+func (t *Twist) GobDecode(b []byte) error         { return decodeFields(b, t) }
+func (m LinearMotion) GobEncode() ([]byte, error) { return encodeFields(&m) }
+func (m *LinearMotion) GobDecode(b []byte) error  { return decodeFields(b, m) }
+func (m KeyframedMotion) GobEncode() ([]byte, error) {
+	return encodeFields(&m)
+}
+func (m *KeyframedMotion) GobDecode(b []byte) error { return decodeFields(b, m) }
+func (t Track[T]) GobEncode() ([]byte, error)       { return encodeFields(&t) }
+func (t *Track[T]) GobDecode(b []byte) error        { return decodeFields(b, t) }
+func (k Keyframe[T]) GobEncode() ([]byte, error)    { return encodeFields(&k) }
+func (k *Keyframe[T]) GobDecode(b []byte) error     { return decodeFields(b, k) }
+
+// Matériaux et textures
+func (l Lambert) GobEncode() ([]byte, error)         { return encodeFields(&l) }
+func (l *Lambert) GobDecode(b []byte) error          { return decodeFields(b, l) }
+func (p Phong) GobEncode() ([]byte, error)           { return encodeFields(&p) }
+func (p *Phong) GobDecode(b []byte) error            { return decodeFields(b, p) }
+func (c CookTorrance) GobEncode() ([]byte, error)    { return encodeFields(&c) }
+func (c *CookTorrance) GobDecode(b []byte) error     { return decodeFields(b, c) }
+func (e Emissive) GobEncode() ([]byte, error)        { return encodeFields(&e) }
+func (e *Emissive) GobDecode(b []byte) error         { return decodeFields(b, e) }
+func (t Toon) GobEncode() ([]byte, error)            { return encodeFields(&t) }
+func (t *Toon) GobDecode(b []byte) error             { return decodeFields(b, t) }
+func (d Dielectric) GobEncode() ([]byte, error)      { return encodeFields(&d) }
+func (d *Dielectric) GobDecode(b []byte) error       { return decodeFields(b, d) }
+func (s Subsurface) GobEncode() ([]byte, error)      { return encodeFields(&s) }
+func (s *Subsurface) GobDecode(b []byte) error       { return decodeFields(b, s) }
+func (m NormalMapped) GobEncode() ([]byte, error)    { return encodeFields(&m) }
+func (m *NormalMapped) GobDecode(b []byte) error     { return decodeFields(b, m) }
+func (t TerrainMaterial) GobEncode() ([]byte, error) { return encodeFields(&t) }
+func (t *TerrainMaterial) GobDecode(b []byte) error  { return decodeFields(b, t) }
+func (l TerrainLayer) GobEncode() ([]byte, error)    { return encodeFields(&l) }
+func (l *TerrainLayer) GobDecode(b []byte) error     { return decodeFields(b, l) }
+func (t CheckerTexture) GobEncode() ([]byte, error)  { return encodeFields(&t) }
+func (t *CheckerTexture) GobDecode(b []byte) error   { return decodeFields(b, t) }
+func (t ImageTexture) GobEncode() ([]byte, error)    { return encodeFields(&t) }
+func (t *ImageTexture) GobDecode(b []byte) error     { return decodeFields(b, t) }
+func (t NoiseTexture) GobEncode() ([]byte, error)    { return encodeFields(&t) }
+func (t *NoiseTexture) GobDecode(b []byte) error     { return decodeFields(b, t) }
+func (n Noise) GobEncode() ([]byte, error)           { return encodeFields(&n) }
+func (n *Noise) GobDecode(b []byte) error            { return decodeFields(b, n) }
+func (s TabulatedSpectrum) GobEncode() ([]byte, error) {
+	return encodeFields(&s)
+}
+func (s *TabulatedSpectrum) GobDecode(b []byte) error { return decodeFields(b, s) }
+
+// Sources, environnements et milieux
+func (l Light) GobEncode() ([]byte, error)               { return encodeFields(&l) }
+func (l *Light) GobDecode(b []byte) error                { return decodeFields(b, l) }
+func (l PointLight) GobEncode() ([]byte, error)          { return encodeFields(&l) }
+func (l *PointLight) GobDecode(b []byte) error           { return decodeFields(b, l) }
+func (l SpotLight) GobEncode() ([]byte, error)           { return encodeFields(&l) }
+func (l *SpotLight) GobDecode(b []byte) error            { return decodeFields(b, l) }
+func (l DirectionalLight) GobEncode() ([]byte, error)    { return encodeFields(&l) }
+func (l *DirectionalLight) GobDecode(b []byte) error     { return decodeFields(b, l) }
+func (a Attenuation) GobEncode() ([]byte, error)         { return encodeFields(&a) }
+func (a *Attenuation) GobDecode(b []byte) error          { return decodeFields(b, a) }
+func (e ConstantEnvironment) GobEncode() ([]byte, error) { return encodeFields(&e) }
+func (e *ConstantEnvironment) GobDecode(b []byte) error  { return decodeFields(b, e) }
+func (e GradientEnvironment) GobEncode() ([]byte, error) { return encodeFields(&e) }
+func (e *GradientEnvironment) GobDecode(b []byte) error  { return decodeFields(b, e) }
+func (e SunSky) GobEncode() ([]byte, error)              { return encodeFields(&e) }
+func (e *SunSky) GobDecode(b []byte) error               { return decodeFields(b, e) }
+func (e EnvironmentMap) GobEncode() ([]byte, error)      { return encodeFields(&e) }
+func (e *EnvironmentMap) GobDecode(b []byte) error       { return decodeFields(b, e) }
+func (d distribution1D) GobEncode() ([]byte, error)      { return encodeFields(&d) }
+func (d *distribution1D) GobDecode(b []byte) error       { return decodeFields(b, d) }
+func (f Fog) GobEncode() ([]byte, error)                 { return encodeFields(&f) }
+func (f *Fog) GobDecode(b []byte) error                  { return decodeFields(b, f) }
+func (v HomogeneousVolume) GobEncode() ([]byte, error)   { return encodeFields(&v) }
+func (v *HomogeneousVolume) GobDecode(b []byte) error    { return decodeFields(b, v) }
+func (v GridVolume) GobEncode() ([]byte, error)          { return encodeFields(&v) }
+func (v *GridVolume) GobDecode(b []byte) error           { return decodeFields(b, v) }
+func (g DensityGrid) GobEncode() ([]byte, error)         { return encodeFields(&g) }
+func (g *DensityGrid) GobDecode(b []byte) error          { return decodeFields(b, g) }
+func (m mediumCoefficients) GobEncode() ([]byte, error)  { return encodeFields(&m) }
+func (m *mediumCoefficients) GobDecode(b []byte) error   { return decodeFields(b, m) }
+func (h HenyeyGreenstein) GobEncode() ([]byte, error)    { return encodeFields(&h) }
+func (h *HenyeyGreenstein) GobDecode(b []byte) error     { return decodeFields(b, h) }
+
+// Intégrateurs. Les cartes de photons sont tracées par prepare sur le
+// client.
+var photonDerived = []string{"causticMap", "maps", "radii"}
+
+func (p PathTracer) GobEncode() ([]byte, error)       { return encodeFields(&p) }
+func (p *PathTracer) GobDecode(b []byte) error        { return decodeFields(b, p) }
+func (a AmbientOcclusion) GobEncode() ([]byte, error) { return encodeFields(&a) }
+func (a *AmbientOcclusion) GobDecode(b []byte) error  { return decodeFields(b, a) }
+func (d DepthShading) GobEncode() ([]byte, error)     { return encodeFields(&d) }
+func (d *DepthShading) GobDecode(b []byte) error      { return decodeFields(b, d) }
+func (h BVHHeatmap) GobEncode() ([]byte, error)       { return encodeFields(&h) }
+func (h *BVHHeatmap) GobDecode(b []byte) error        { return decodeFields(b, h) }
+func (p PhotonMapper) GobEncode() ([]byte, error)     { return encodeFields(&p, photonDerived...) }
+func (p *PhotonMapper) GobDecode(b []byte) error      { return decodeFields(b, p, photonDerived...) }
+func (p ProgressivePhotonMapper) GobEncode() ([]byte, error) {
+	return encodeFields(&p, photonDerived...)
+}
+func (p *ProgressivePhotonMapper) GobDecode(b []byte) error {
+	return decodeFields(b, p, photonDerived...)
+}
+func (p SpectralPathTracer) GobEncode() ([]byte, error) { return encodeFields(&p) }
+func (p *SpectralPathTracer) GobDecode(b []byte) error  { return decodeFields(b, p) }
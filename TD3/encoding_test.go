@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+// Un job doit traverser gob sans perte : la tuile rendue par le client est
+// la même que celle rendue avant l'envoi.
+func TestRenderJobRoundTrip(t *testing.T) {
+	integrators := []Integrator{
+		DirectLighting{},
+		PathTracer{4},
+		SpectralPathTracer{4},
+		NewAmbientOcclusion(1, 2),
+		NormalShading{},
+		NewDepthShading(20),
+		NewBVHHeatmap(50),
+		NewPhotonMapper(4, 2000, 10, 0.2),
+		NewProgressivePhotonMapper(4, 1000, 2, 0.2),
+	}
+	scenes := []string{
+		"area_lights_scene.json", "caustics_scene.json", "csg_scene.json", "environment_scene.json",
+		"sdf_scene.json", "subsurface_scene.json", "terrain_scene.json",
+		"toon_scene.json", "volumes_scene.json", "spectral_scene.json", "forest_scene.json",
+	}
+	const width, height = 12, 9
+	for _, path := range scenes {
+		scene, camera, err := loadScene(path, NewCamera(Vec3f{0, 0, -5}, Vec3f{0, 1, 0}, Vec3f{0, 0, 5}))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, integrator := range integrators {
+			job := RenderJob{
+				EndX: width, EndY: height, Width: width, Height: height,
+				Camera: camera, Scene: scene,
+				Options: RenderOptions{AOVs: AOVSet{AOVDepth, AOVNormal}, Integrator: integrator},
+			}
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(job); err != nil {
+				t.Fatalf("%s, %T: encode: %v", path, integrator, err)
+			}
+			var received RenderJob
+			if err := gob.NewDecoder(&buf).Decode(&received); err != nil {
+				t.Fatalf("%s, %T: decode: %v", path, integrator, err)
+			}
+
+			want, _, _ := renderTile(job.Scene.prepared(), job.Camera, 0, 0, width, height, width, height, job.Options)
+			got, layers, _ := renderTile(received.Scene.prepared(), received.Camera, 0, 0, width, height, width, height, received.Options)
+			if len(layers) != 3 {
+				t.Errorf("%s, %T: %d layers, want the beauty pass and 2 AOVs", path, integrator, len(layers))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s, %T: pixel %d is %v after the round trip, want %v", path, integrator, i, got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+// Les instances d'un maillage partagent un seul *Mesh chez le client, comme
+// dans la scène envoyée.
+func TestSceneKeepsSharedMeshes(t *testing.T) {
+	scene, _, err := loadScene("forest_scene.json", NewCamera(Vec3f{0, 0, -5}, Vec3f{0, 1, 0}, Vec3f{0, 0, 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(scene); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var received Scene
+	if err := gob.NewDecoder(&buf).Decode(&received); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	instances := 0
+	meshes := map[*Mesh]bool{}
+	received.mapObjects(func(object GeometricObject) GeometricObject {
+		if mesh, ok := object.(*Mesh); ok {
+			instances++
+			meshes[mesh] = true
+		}
+		return object
+	})
+	if instances < 2 || len(meshes) != 1 {
+		t.Errorf("%d instances share %d meshes after decoding, want a single mesh", instances, len(meshes))
+	}
+	if got, want := len(received.prepared().accel.objects), len(scene.prepared().accel.objects); got != want {
+		t.Errorf("%d objects after decoding, want %d", got, want)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ------------------------------
+// Environnement : radiance reçue de l'infini dans chaque direction, rendue
+// par les rayons qui ne touchent rien. Le tracé de chemins l'échantillonne
+// comme une source de lumière, selon sa propre densité.
+type Environment interface {
+	radiance(direction Vec3f) Vec3f
+	// sample tire une direction et renvoie sa densité par angle solide.
+	sample(u1, u2 float32) (Vec3f, float32)
+	pdf(direction Vec3f) float32
+}
+
+// background : radiance des rayons perdus, noire sans environnement.
+func (s Scene) background(ray Ray) Vec3f {
+	if s.environment == nil {
+		return Vec3f{}
+	}
+	return s.environment.radiance(ray.direction.normalized())
+}
+
+const uniformSpherePdf = 1 / (4 * math.Pi)
+
+// ConstantEnvironment : ciel uniforme
+type ConstantEnvironment struct {
+	color Vec3f
+}
+
+func (e ConstantEnvironment) radiance(direction Vec3f) Vec3f { return e.color }
+
+func (e ConstantEnvironment) sample(u1, u2 float32) (Vec3f, float32) {
+	return uniformSphere(u1, u2), uniformSpherePdf
+}
+
+func (e ConstantEnvironment) pdf(direction Vec3f) float32 { return uniformSpherePdf }
+
+// GradientEnvironment : dégradé de l'horizon au zénith, et de l'horizon au
+// sol sous l'horizon (axe Y vers le haut).
+type GradientEnvironment struct {
+	zenith, horizon, ground Vec3f
+}
+
+func (e GradientEnvironment) radiance(direction Vec3f) Vec3f {
+	if direction.y >= 0 {
+		return lerpVec3f(e.horizon, e.zenith, sqrt32(direction.y))
+	}
+	return lerpVec3f(e.horizon, e.ground, smoothstep(0, 0.2, -direction.y))
+}
+
+func (e GradientEnvironment) sample(u1, u2 float32) (Vec3f, float32) {
+	return uniformSphere(u1, u2), uniformSpherePdf
+}
+
+func (e GradientEnvironment) pdf(direction Vec3f) float32 { return uniformSpherePdf }
+
+// ------------------------------
+// SunSky : modèle analytique de ciel de Preetham (1999), fonction de la
+// turbidité et de la hauteur du soleil, et disque solaire dont la couleur
+// suit l'épaisseur d'atmosphère traversée. sunIlluminance est
+// l'éclairement du disque sur une surface qui lui fait face, comme pour
+// DirectionalLight.
+type SunSky struct {
+	sunDirection   Vec3f // vers le soleil
+	turbidity      float32
+	sunIlluminance float32
+	skyIntensity   float32
+	ground         Vec3f
+	sunRadius      float32 // demi-angle apparent, en radians
+
+	// Coefficients de Perez pour Y, x et y, et valeurs au zénith
+	perezY, perezX, perezYc [5]float32
+	zenith                  Vec3f // Y, x, y
+	sunColor                Vec3f
+}
+
+// Demi-angle apparent du soleil, et conversion de la luminance du modèle
+// (kcd/m²) vers les unités de la scène.
+const (
+	sunAngularRadius  = 0.00465
+	skyLuminanceScale = 0.04
+)
+
+func NewSunSky(sunDirection Vec3f, turbidity, sunIlluminance float32) SunSky {
+	s := SunSky{
+		sunDirection:   sunDirection.normalized(),
+		turbidity:      max(turbidity, 1.7),
+		sunIlluminance: sunIlluminance,
+		skyIntensity:   1,
+		ground:         Vec3f{0.1, 0.1, 0.1},
+		sunRadius:      sunAngularRadius,
+	}
+	T := s.turbidity
+	s.perezY = [5]float32{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703}
+	s.perezX = [5]float32{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452}
+	s.perezYc = [5]float32{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529}
+
+	thetaS := float32(math.Acos(float64(clamp32(s.sunDirection.y, 0, 1))))
+	chi := (4.0/9 - T/120) * (math.Pi - 2*thetaS)
+	Yz := (4.0453*T-4.9710)*float32(math.Tan(float64(chi))) - 0.2155*T + 2.4192
+	t2, t3 := thetaS*thetaS, thetaS*thetaS*thetaS
+	xz := T*T*(0.00166*t3-0.00375*t2+0.00209*thetaS) +
+		T*(-0.02903*t3+0.06377*t2-0.03202*thetaS+0.00394) +
+		(0.11693*t3 - 0.21196*t2 + 0.06052*thetaS + 0.25886)
+	yz := T*T*(0.00275*t3-0.00610*t2+0.00317*thetaS) +
+		T*(-0.04214*t3+0.08970*t2-0.04153*thetaS+0.00516) +
+		(0.15346*t3 - 0.26756*t2 + 0.06670*thetaS + 0.26688)
+	s.zenith = Vec3f{max(Yz, 0), xz, yz}
+
+	// Transmittance par canal selon la masse d'air (Kasten et Young)
+	elevation := 90 - thetaS*180/math.Pi
+	airMass := 1 / (float32(math.Cos(float64(thetaS))) + 0.50572*float32(math.Pow(float64(max(elevation, 0)+6.07995), -1.6364)))
+	extinction := Vec3f{0.02, 0.045, 0.1}.mul(T)
+	s.sunColor = Vec3f{
+		float32(math.Exp(float64(-extinction.x * airMass))),
+		float32(math.Exp(float64(-extinction.y * airMass))),
+		float32(math.Exp(float64(-extinction.z * airMass))),
+	}
+	return s
+}
+
+func perez(c [5]float32, cosTheta, gamma float32) float32 {
+	cosGamma := float32(math.Cos(float64(gamma)))
+	return (1 + c[0]*float32(math.Exp(float64(c[1]/cosTheta)))) *
+		(1 + c[2]*float32(math.Exp(float64(c[3]*gamma))) + c[4]*cosGamma*cosGamma)
+}
+
+// xyYToRGB convertit une chromaticité xyY en RGB linéaire (primaires sRGB).
+func xyYToRGB(x, y, Y float32) Vec3f {
+	if y <= 0 {
+		return Vec3f{}
+	}
+	X := x / y * Y
+	Z := (1 - x - y) / y * Y
+	return Vec3f{
+		3.2406*X - 1.5372*Y - 0.4986*Z,
+		-0.9689*X + 1.8758*Y + 0.0415*Z,
+		0.0557*X - 0.2040*Y + 1.0570*Z,
+	}
+}
+
+func (s SunSky) sky(direction Vec3f) Vec3f {
+	// Le modèle n'est pas défini sous l'horizon : on le prolonge
+	cosTheta := max(direction.y, 0.01)
+	gamma := float32(math.Acos(float64(clamp32(Dot(direction, s.sunDirection), -1, 1))))
+	thetaS := float32(math.Acos(float64(clamp32(s.sunDirection.y, 0, 1))))
+	zero := func(c [5]float32) float32 { return perez(c, 1, thetaS) }
+
+	Y := s.zenith.x * perez(s.perezY, cosTheta, gamma) / zero(s.perezY)
+	x := s.zenith.y * perez(s.perezX, cosTheta, gamma) / zero(s.perezX)
+	y := s.zenith.z * perez(s.perezYc, cosTheta, gamma) / zero(s.perezYc)
+	c := xyYToRGB(x, y, Y*skyLuminanceScale*s.skyIntensity)
+	return Vec3f{max(c.x, 0), max(c.y, 0), max(c.z, 0)}
+}
+
+func (s SunSky) cosSunRadius() float32 {
+	return float32(math.Cos(float64(s.sunRadius)))
+}
+
+// sunRadiance : radiance uniforme du disque donnant l'éclairement voulu
+func (s SunSky) sunRadiance() Vec3f {
+	solidAngle := 2 * math.Pi * (1 - s.cosSunRadius())
+	return s.sunColor.mul(s.sunIlluminance / solidAngle)
+}
+
+func (s SunSky) radiance(direction Vec3f) Vec3f {
+	if direction.y < 0 {
+		horizon := s.sky(Vec3f{direction.x, 0, direction.z}.normalized())
+		return lerpVec3f(horizon, s.ground, smoothstep(0, 0.2, -direction.y))
+	}
+	c := s.sky(direction)
+	if Dot(direction, s.sunDirection) >= s.cosSunRadius() {
+		c = Add(c, s.sunRadiance())
+	}
+	return c
+}
+
+// Part des directions tirées dans le disque solaire quand il est levé
+const sunSampleProbability = 0.5
+
+func (s SunSky) sunProbability() float32 {
+	if s.sunIlluminance <= 0 || s.sunDirection.y < -s.sunRadius {
+		return 0
+	}
+	return sunSampleProbability
+}
+
+func (s SunSky) sample(u1, u2 float32) (Vec3f, float32) {
+	var direction Vec3f
+	if p := s.sunProbability(); u1 < p {
+		direction = uniformCone(s.sunDirection, s.cosSunRadius(), u1/p, u2)
+	} else {
+		direction = uniformSphere((u1-p)/(1-p), u2)
+	}
+	return direction, s.pdf(direction)
+}
+
+func (s SunSky) pdf(direction Vec3f) float32 {
+	p := s.sunProbability()
+	res := (1 - p) * uniformSpherePdf
+	if p > 0 && Dot(direction, s.sunDirection) >= s.cosSunRadius() {
+		res += p / (2 * math.Pi * (1 - s.cosSunRadius()))
+	}
+	return res
+}
+
+// ------------------------------
+// EnvironmentMap : image équirectangulaire (longitude en x, de -Z à +Z par
+// +X au centre, latitude en y, zénith en haut), tournée de rotation radians
+// autour de Y. Les texels sont tirés proportionnellement à leur luminance
+// et à l'aire qu'ils couvrent sur la sphère ; la radiance est constante par
+// texel pour rester cohérente avec cette densité.
+type EnvironmentMap struct {
+	pixels        []Vec3f
+	width, height int
+	intensity     float32
+	rotation      float32
+
+	rows    distribution1D   // choix de la ligne
+	columns []distribution1D // choix de la colonne dans chaque ligne
+}
+
+func NewEnvironmentMap(pixels []Vec3f, width, height int, intensity float32) EnvironmentMap {
+	e := EnvironmentMap{pixels: pixels, width: width, height: height, intensity: intensity}
+	rowWeights := make([]float32, height)
+	e.columns = make([]distribution1D, height)
+	weights := make([]float32, width)
+	for y := 0; y < height; y++ {
+		sin := float32(math.Sin(math.Pi * (float64(y) + 0.5) / float64(height)))
+		total := float32(0)
+		for x := 0; x < width; x++ {
+			weights[x] = luminance(pixels[y*width+x]) * sin
+			total += weights[x]
+		}
+		e.columns[y] = newDistribution1D(weights)
+		rowWeights[y] = total
+	}
+	e.rows = newDistribution1D(rowWeights)
+	return e
+}
+
+// LoadEnvironmentMap lit une image HDR Radiance (.hdr) ou une image PNG /
+// JPEG.
+func LoadEnvironmentMap(path string, intensity float32) (EnvironmentMap, error) {
+	if strings.EqualFold(filepath.Ext(path), ".hdr") {
+		pixels, width, height, err := loadRadianceHDR(path)
+		if err != nil {
+			return EnvironmentMap{}, fmt.Errorf("environment map %s: %v", path, err)
+		}
+		return NewEnvironmentMap(pixels, width, height, intensity), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return EnvironmentMap{}, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return EnvironmentMap{}, fmt.Errorf("environment map %s: %v", path, err)
+	}
+	r := img.Bounds()
+	pixels := make([]Vec3f, r.Dx()*r.Dy())
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			cr, cg, cb, _ := img.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			pixels[y*r.Dx()+x] = Vec3f{float32(cr), float32(cg), float32(cb)}.mul(1.0 / 0xffff)
+		}
+	}
+	return NewEnvironmentMap(pixels, r.Dx(), r.Dy(), intensity), nil
+}
+
+// uv renvoie les coordonnées de la direction dans l'image, dans [0, 1[².
+func (e EnvironmentMap) uv(direction Vec3f) (float32, float32) {
+	phi := math.Atan2(float64(direction.x), float64(direction.z)) - float64(e.rotation)
+	u := float32(phi/(2*math.Pi)) + 0.5
+	u -= float32(math.Floor(float64(u)))
+	v := float32(math.Acos(float64(clamp32(direction.y, -1, 1))) / math.Pi)
+	return u, v
+}
+
+func (e EnvironmentMap) direction(u, v float32) Vec3f {
+	phi := float64(u-0.5)*2*math.Pi + float64(e.rotation)
+	theta := float64(v) * math.Pi
+	sin := float32(math.Sin(theta))
+	return Vec3f{sin * float32(math.Sin(phi)), float32(math.Cos(theta)), sin * float32(math.Cos(phi))}
+}
+
+func (e EnvironmentMap) texel(u, v float32) (int, int) {
+	return min(int(u*float32(e.width)), e.width-1), min(int(v*float32(e.height)), e.height-1)
+}
+
+func (e EnvironmentMap) radiance(direction Vec3f) Vec3f {
+	x, y := e.texel(e.uv(direction))
+	return e.pixels[y*e.width+x].mul(e.intensity)
+}
+
+// La densité par angle solide se déduit de celle sur l'image par le
+// jacobien 2π² sin θ de la projection équirectangulaire.
+func (e EnvironmentMap) solidAnglePdf(x, y int, v float32) float32 {
+	sin := float32(math.Sin(float64(v) * math.Pi))
+	if sin <= 0 {
+		return 0
+	}
+	pdfUV := e.rows.probability(y) * e.columns[y].probability(x) * float32(e.width*e.height)
+	return pdfUV / (2 * math.Pi * math.Pi * sin)
+}
+
+func (e EnvironmentMap) sample(u1, u2 float32) (Vec3f, float32) {
+	y, fv, _ := e.rows.sample(u1)
+	x, fu, _ := e.columns[y].sample(u2)
+	u := (float32(x) + fu) / float32(e.width)
+	v := (float32(y) + fv) / float32(e.height)
+	return e.direction(u, v), e.solidAnglePdf(x, y, v)
+}
+
+func (e EnvironmentMap) pdf(direction Vec3f) float32 {
+	u, v := e.uv(direction)
+	x, y := e.texel(u, v)
+	return e.solidAnglePdf(x, y, v)
+}
+
+// ------------------------------
+// loadRadianceHDR lit une image Radiance RGBE, non compressée ou encodée
+// par plages (format « nouveau » par composante).
+func loadRadianceHDR(path string) ([]Vec3f, int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	magic, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(magic, "#?") {
+		return nil, 0, 0, fmt.Errorf("not a Radiance HDR file")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("truncated header")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, 0, 0, fmt.Errorf("unsupported format %s", line)
+		}
+	}
+	var width, height int
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("missing resolution")
+	}
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil || width <= 0 || height <= 0 {
+		return nil, 0, 0, fmt.Errorf("unsupported resolution line %q", strings.TrimSpace(resolution))
+	}
+
+	pixels := make([]Vec3f, width*height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readRGBEScanline(r, scanline, width); err != nil {
+			return nil, 0, 0, fmt.Errorf("scanline %d: %v", y, err)
+		}
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = rgbe(scanline[4*x], scanline[4*x+1], scanline[4*x+2], scanline[4*x+3])
+		}
+	}
+	return pixels, width, height, nil
+}
+
+func rgbe(r, g, b, e byte) Vec3f {
+	if e == 0 {
+		return Vec3f{}
+	}
+	f := float32(math.Ldexp(1, int(e)-136))
+	return Vec3f{float32(r) * f, float32(g) * f, float32(b) * f}
+}
+
+// readRGBEScanline remplit dst (4 octets par pixel, entrelacés).
+func readRGBEScanline(r *bufio.Reader, dst []byte, width int) error {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		// Scanline non compressée
+		copy(dst, head)
+		_, err := io.ReadFull(r, dst[4:])
+		return err
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return fmt.Errorf("scanline width mismatch")
+	}
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count) - 128
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return fmt.Errorf("run overflows scanline")
+				}
+				for ; n > 0; n-- {
+					dst[4*x+c] = value
+					x++
+				}
+			} else {
+				n := int(count)
+				if n == 0 || x+n > width {
+					return fmt.Errorf("invalid run")
+				}
+				for ; n > 0; n-- {
+					value, err := r.ReadByte()
+					if err != nil {
+						return err
+					}
+					dst[4*x+c] = value
+					x++
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"sort"
+)
+
+// ------------------------------
+// saveEXR écrit des couches en OpenEXR multi-couches, une image à lignes
+// non compressées en flottants 32 bits. Chaque composante s'appelle
+// <couche>.<composante> ; l'image finale occupe R, G et B sans préfixe
+// pour que les visionneuses l'affichent par défaut.
+func saveEXR(path string, layers []AOVLayer, width, height int) error {
+	type channel struct {
+		name   string
+		layer  AOVLayer
+		offset int
+	}
+	var channels []channel
+	for _, l := range layers {
+		names := exrChannelNames(l)
+		for c, name := range names {
+			if l.Name != beautyLayer {
+				name = l.Name + "." + name
+			}
+			channels = append(channels, channel{name, l, c})
+		}
+	}
+	// OpenEXR impose l'ordre alphabétique des composantes
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+
+	// L'en-tête est construit à part pour connaître la position des lignes
+	var h bytes.Buffer
+	le := binary.LittleEndian
+	version := uint32(2)
+	for _, c := range channels {
+		if len(c.name) > 31 {
+			version |= 0x400 // noms longs
+		}
+	}
+	binary.Write(&h, le, uint32(20000630))
+	binary.Write(&h, le, version)
+
+	attribute := func(name, kind string, size int) {
+		h.WriteString(name)
+		h.WriteByte(0)
+		h.WriteString(kind)
+		h.WriteByte(0)
+		binary.Write(&h, le, int32(size))
+	}
+	chlistSize := 1
+	for _, c := range channels {
+		chlistSize += len(c.name) + 1 + 16
+	}
+	attribute("channels", "chlist", chlistSize)
+	for _, c := range channels {
+		h.WriteString(c.name)
+		h.WriteByte(0)
+		binary.Write(&h, le, int32(2)) // FLOAT
+		h.Write([]byte{0, 0, 0, 0})    // pLinear et réservés
+		binary.Write(&h, le, [2]int32{1, 1})
+	}
+	h.WriteByte(0)
+
+	window := [4]int32{0, 0, int32(width - 1), int32(height - 1)}
+	attribute("compression", "compression", 1)
+	h.WriteByte(0) // NO_COMPRESSION
+	attribute("dataWindow", "box2i", 16)
+	binary.Write(&h, le, window)
+	attribute("displayWindow", "box2i", 16)
+	binary.Write(&h, le, window)
+	attribute("lineOrder", "lineOrder", 1)
+	h.WriteByte(0) // INCREASING_Y
+	attribute("pixelAspectRatio", "float", 4)
+	binary.Write(&h, le, float32(1))
+	attribute("screenWindowCenter", "v2f", 8)
+	binary.Write(&h, le, [2]float32{0, 0})
+	attribute("screenWindowWidth", "float", 4)
+	binary.Write(&h, le, float32(1))
+	h.WriteByte(0) // fin de l'en-tête
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	w.Write(h.Bytes())
+
+	// Table des positions des lignes, puis les lignes elles-mêmes
+	lineSize := 4 * width * len(channels)
+	start := uint64(h.Len() + 8*height)
+	for y := 0; y < height; y++ {
+		binary.Write(w, le, start+uint64(y*(8+lineSize)))
+	}
+	line := make([]byte, lineSize)
+	for y := 0; y < height; y++ {
+		binary.Write(w, le, int32(y))
+		binary.Write(w, le, int32(lineSize))
+		i := 0
+		for _, c := range channels {
+			for x := 0; x < width; x++ {
+				v := c.layer.Data[(y*width+x)*c.layer.Channels+c.offset]
+				le.PutUint32(line[i:], math.Float32bits(v))
+				i += 4
+			}
+		}
+		w.Write(line)
+	}
+	return w.Flush()
+}
+
+func exrChannelNames(l AOVLayer) []string {
+	switch {
+	case l.Name == AOVDepth:
+		return []string{"Z"}
+	case l.Name == AOVNormal:
+		return []string{"X", "Y", "Z"}
+	case l.Channels == 1:
+		return []string{"id"}
+	}
+	return []string{"R", "G", "B"}
+}
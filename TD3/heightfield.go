@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+)
+
+// ------------------------------
+// Terrain : grille régulière d'altitudes dans le plan XZ, couvrant size à
+// partir du coin origin. Chaque cellule est découpée en deux triangles.
+type Heightfield struct {
+	heights          []float32 // altitudes monde, nx * nz, ligne par ligne selon z
+	normals          []Vec3f   // normales lissées aux sommets
+	cellMin, cellMax []float32 // altitudes extrêmes de chaque cellule
+	nx, nz           int
+	origin, size     Vec3f
+	ymin, ymax       float32
+	Material         Materials
+}
+
+// NewHeightfield échantillonne height, qui renvoie une altitude dans [0, 1]
+// pour (u, v) dans [0, 1]², sur une grille de nx * nz sommets.
+func NewHeightfield(nx, nz int, height func(u, v float32) float32, origin, size Vec3f, material Materials) Heightfield {
+	h := Heightfield{nx: nx, nz: nz, origin: origin, size: size, Material: material}
+	h.heights = make([]float32, nx*nz)
+	h.ymin, h.ymax = float32(math.Inf(1)), float32(math.Inf(-1))
+	for j := 0; j < nz; j++ {
+		for i := 0; i < nx; i++ {
+			y := origin.y + height(float32(i)/float32(nx-1), float32(j)/float32(nz-1))*size.y
+			h.heights[j*nx+i] = y
+			h.ymin, h.ymax = min(h.ymin, y), max(h.ymax, y)
+		}
+	}
+
+	dx, dz := h.cellSize()
+	h.normals = make([]Vec3f, nx*nz)
+	for j := 0; j < nz; j++ {
+		for i := 0; i < nx; i++ {
+			// Différences centrées, décentrées sur les bords
+			i0, i1 := max(i-1, 0), min(i+1, nx-1)
+			j0, j1 := max(j-1, 0), min(j+1, nz-1)
+			dhdx := (h.height(i1, j) - h.height(i0, j)) / (float32(i1-i0) * dx)
+			dhdz := (h.height(i, j1) - h.height(i, j0)) / (float32(j1-j0) * dz)
+			h.normals[j*nx+i] = Vec3f{-dhdx, 1, -dhdz}.normalized()
+		}
+	}
+
+	h.cellMin = make([]float32, (nx-1)*(nz-1))
+	h.cellMax = make([]float32, (nx-1)*(nz-1))
+	for j := 0; j < nz-1; j++ {
+		for i := 0; i < nx-1; i++ {
+			a, b, c, d := h.height(i, j), h.height(i+1, j), h.height(i, j+1), h.height(i+1, j+1)
+			h.cellMin[j*(nx-1)+i] = min(a, b, c, d)
+			h.cellMax[j*(nx-1)+i] = max(a, b, c, d)
+		}
+	}
+	return h
+}
+
+// NewHeightfieldFromImage lit une image en niveaux de gris : noir en bas,
+// blanc à origin.y + size.y. Un pixel par sommet.
+func NewHeightfieldFromImage(path string, origin, size Vec3f, material Materials) (Heightfield, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Heightfield{}, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return Heightfield{}, fmt.Errorf("heightmap %s: %v", path, err)
+	}
+
+	r := img.Bounds()
+	if r.Dx() < 2 || r.Dy() < 2 {
+		return Heightfield{}, fmt.Errorf("heightmap %s: image too small", path)
+	}
+	height := func(u, v float32) float32 {
+		x := r.Min.X + int(u*float32(r.Dx()-1)+0.5)
+		y := r.Min.Y + int(v*float32(r.Dy()-1)+0.5)
+		return float32(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y) / 0xffff
+	}
+	return NewHeightfield(r.Dx(), r.Dy(), height, origin, size, material), nil
+}
+
+// NewHeightfieldFromNoise échantillonne un bruit fractal de fréquence
+// frequency (en périodes sur toute la largeur du terrain).
+func NewHeightfieldFromNoise(noise *Noise, octaves int, frequency float32, resolution int, origin, size Vec3f, material Materials) Heightfield {
+	height := func(u, v float32) float32 {
+		return 0.5 + 0.5*fbm(noise.perlin, Vec3f{u * frequency, 0.5, v * frequency}, octaves)
+	}
+	return NewHeightfield(resolution, resolution, height, origin, size, material)
+}
+
+func (h Heightfield) height(i, j int) float32 {
+	return h.heights[j*h.nx+i]
+}
+
+func (h Heightfield) vertex(i, j int) Vec3f {
+	dx, dz := h.cellSize()
+	return Vec3f{h.origin.x + float32(i)*dx, h.height(i, j), h.origin.z + float32(j)*dz}
+}
+
+func (h Heightfield) cellSize() (float32, float32) {
+	return h.size.x / float32(h.nx-1), h.size.z / float32(h.nz-1)
+}
+
+func (h Heightfield) bounds() Bounds {
+	return Bounds{
+		Vec3f{h.origin.x, h.ymin, h.origin.z},
+		Vec3f{h.origin.x + h.size.x, h.ymax, h.origin.z + h.size.z},
+	}
+}
+
+func (h Heightfield) material() Materials {
+	return h.Material
+}
+
+func (h Heightfield) withMaterial(m Materials) GeometricObject {
+	h.Material = m
+	return h
+}
+
+// gridStep prépare la traversée d'un axe : pas de cellule, distance jusqu'à
+// la prochaine frontière et distance entre deux frontières.
+func gridStep(cell int, origin, position, direction, size, t float32) (int, float32, float32) {
+	switch {
+	case direction > 0:
+		return 1, t + (origin+float32(cell+1)*size-position)/direction, size / direction
+	case direction < 0:
+		return -1, t + (origin+float32(cell)*size-position)/direction, -size / direction
+	}
+	inf := float32(math.Inf(1))
+	return 0, inf, inf
+}
+
+// intersect parcourt les cellules traversées par la projection du rayon
+// (algorithme d'Amanatides et Woo) et s'arrête à la première touchée.
+func (h Heightfield) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	b := h.bounds()
+	ok, tnear, tfar := clipBox(ray.origin, ray.direction, b.min, b.max)
+	if !ok {
+		return HitRecord{}, false
+	}
+	t, tfar := max(tnear, tmin), min(tfar, tmax)
+	if t > tfar {
+		return HitRecord{}, false
+	}
+
+	dx, dz := h.cellSize()
+	p := ray.at(t)
+	i := max(0, min(int((p.x-h.origin.x)/dx), h.nx-2))
+	j := max(0, min(int((p.z-h.origin.z)/dz), h.nz-2))
+	stepI, nextX, deltaX := gridStep(i, h.origin.x, p.x, ray.direction.x, dx, t)
+	stepJ, nextZ, deltaZ := gridStep(j, h.origin.z, p.z, ray.direction.z, dz, t)
+
+	for t <= tfar {
+		exit := min(nextX, nextZ, tfar)
+		// Rejet rapide : le rayon reste au-dessus ou au-dessous de la cellule
+		y0, y1 := ray.origin.y+t*ray.direction.y, ray.origin.y+exit*ray.direction.y
+		cell := j*(h.nx-1) + i
+		if min(y0, y1) <= h.cellMax[cell] && max(y0, y1) >= h.cellMin[cell] {
+			if hit, ok := h.intersectCell(ray, i, j, tmin, tmax); ok {
+				return hit, true
+			}
+		}
+
+		if exit >= tfar {
+			break
+		}
+		t = exit
+		if nextX < nextZ {
+			i += stepI
+			nextX += deltaX
+		} else {
+			j += stepJ
+			nextZ += deltaZ
+		}
+		if i < 0 || i >= h.nx-1 || j < 0 || j >= h.nz-1 {
+			break
+		}
+	}
+	return HitRecord{}, false
+}
+
+func (h Heightfield) intersectCell(ray Ray, i, j int, tmin, tmax float32) (HitRecord, bool) {
+	corners := [4][2]int{{i, j}, {i + 1, j}, {i + 1, j + 1}, {i, j + 1}}
+	found := false
+	var bary Vec2f
+	var tri [3][2]int
+	for _, t := range [2][3][2]int{{corners[0], corners[1], corners[2]}, {corners[0], corners[2], corners[3]}} {
+		ok, d, uv := intersectTriangle(ray, h.vertex(t[0][0], t[0][1]), h.vertex(t[1][0], t[1][1]), h.vertex(t[2][0], t[2][1]))
+		if ok && d > tmin && d < tmax {
+			found, tmax, bary, tri = true, d, uv, t
+		}
+	}
+	if !found {
+		return HitRecord{}, false
+	}
+
+	normal := func(c [2]int) Vec3f {
+		return h.normals[c[1]*h.nx+c[0]]
+	}
+	n := Add(Add(normal(tri[0]).mul(1-bary.x-bary.y), normal(tri[1]).mul(bary.x)), normal(tri[2]).mul(bary.y)).normalized()
+	p := ray.at(tmax)
+	uv := Vec2f{(p.x - h.origin.x) / h.size.x, (p.z - h.origin.z) / h.size.z}
+	hit := newHit(ray, tmax, n, uv, h.Material)
+	hit.tangent = Vec3f{1, 0, 0}
+	return hit, true
+}
+
+// ------------------------------
+// Matériau de terrain : mélange de couches selon l'altitude (repère monde)
+// et la pente (en degrés). Les transitions s'étalent sur heightBlend et
+// slopeBlend.
+type TerrainLayer struct {
+	material             Materials
+	minHeight, maxHeight float32
+	minSlope, maxSlope   float32
+}
+
+type TerrainMaterial struct {
+	layers                  []TerrainLayer
+	heightBlend, slopeBlend float32
+}
+
+func smoothstep(edge0, edge1, x float32) float32 {
+	if edge0 == edge1 {
+		if x < edge0 {
+			return 0
+		}
+		return 1
+	}
+	t := clamp32((x-edge0)/(edge1-edge0), 0, 1)
+	return t * t * (3 - 2*t)
+}
+
+// band vaut 1 dans [lo, hi] et 0 hors de [lo - width/2, hi + width/2].
+func band(x, lo, hi, width float32) float32 {
+	return smoothstep(lo-width/2, lo+width/2, x) * (1 - smoothstep(hi-width/2, hi+width/2, x))
+}
+
+func (m TerrainMaterial) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	return m.blend(hit, func(layer Materials) Vec3f { return layer.render(ray, hit, scene) })
+}
+
+// blend mêle la valeur de chaque couche selon l'altitude et la pente ; hors
+// de toutes les couches, la dernière s'applique.
+func (m TerrainMaterial) blend(hit HitRecord, value func(Materials) Vec3f) Vec3f {
+	slope := float32(math.Acos(float64(clamp32(abs32(hit.geometricNormal.y), 0, 1))) * 180 / math.Pi)
+	var sum Vec3f
+	total := float32(0)
+	for _, l := range m.layers {
+		w := band(hit.position.y, l.minHeight, l.maxHeight, m.heightBlend) * band(slope, l.minSlope, l.maxSlope, m.slopeBlend)
+		if w > 0 {
+			sum = Add(sum, value(l.material).mul(w))
+			total += w
+		}
+	}
+	if total == 0 {
+		if len(m.layers) == 0 {
+			return Vec3f{}
+		}
+		return value(m.layers[len(m.layers)-1].material)
+	}
+	return sum.mul(1 / total)
+}
@@ -0,0 +1,128 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Intégrateurs de mise au point et de débogage : ils ignorent matériaux et
+// sources pour montrer une seule grandeur de la scène. Les rayons qui
+// ne touchent rien renvoient du noir, sauf pour l'occlusion ambiante.
+
+// AmbientOcclusion : part des directions de l'hémisphère (pondérées par le
+// cosinus) qui ne rencontrent aucun objet à moins de radius.
+type AmbientOcclusion struct {
+	radius  float32
+	samples int
+}
+
+func NewAmbientOcclusion(radius float32, samples int) AmbientOcclusion {
+	return AmbientOcclusion{radius, max(samples, 1)}
+}
+
+func (a AmbientOcclusion) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	if !found {
+		return Vec3f{1, 1, 1}
+	}
+	if m, ok := hit.material.(NormalMapped); ok {
+		hit.shadingNormal = m.perturb(hit)
+	}
+	open := 0
+	for i := 0; i < a.samples; i++ {
+		wi := cosineHemisphere(hit.shadingNormal, rng.float(), rng.float())
+		if !scene.occluded(hit.position, wi, a.radius, ray.time) {
+			open++
+		}
+	}
+	v := float32(open) / float32(a.samples)
+	return Vec3f{v, v, v}
+}
+
+// NormalShading : normale géométrique, sans interpolation ni carte de
+// normales, ramenée de [-1, 1] à [0, 1].
+type NormalShading struct{}
+
+func (NormalShading) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	if !found {
+		return Vec3f{}
+	}
+	return Add(hit.geometricNormal.mul(0.5), Vec3f{0.5, 0.5, 0.5})
+}
+
+// UVShading : coordonnées de texture en rouge et vert, répétées sur [0, 1[.
+type UVShading struct{}
+
+func (UVShading) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	if !found {
+		return Vec3f{}
+	}
+	fract := func(v float32) float32 { return v - float32(math.Floor(float64(v))) }
+	return Vec3f{fract(hit.uv.x), fract(hit.uv.y), 0}
+}
+
+// DepthShading : distance le long du rayon, blanche au contact et noire à
+// far et au-delà.
+type DepthShading struct {
+	far float32
+}
+
+func NewDepthShading(far float32) DepthShading {
+	return DepthShading{max(far, 1e-3)}
+}
+
+func (d DepthShading) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	if !found {
+		return Vec3f{}
+	}
+	v := clamp32(1-hit.t/d.far, 0, 1)
+	return Vec3f{v, v, v}
+}
+
+// FacingRatio : cosinus entre la normale et la direction de vue, clair face
+// à la caméra et sombre sur les bords.
+type FacingRatio struct{}
+
+func (FacingRatio) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	if !found {
+		return Vec3f{}
+	}
+	v := abs32(Dot(hit.shadingNormal, ray.direction.normalized()))
+	return Vec3f{v, v, v}
+}
+
+// BVHHeatmap : travail du rayon de caméra, nœuds du BVH visités plus tests
+// d'intersection, du bleu (rien) au rouge (scale et au-delà).
+type BVHHeatmap struct {
+	scale float32
+}
+
+func NewBVHHeatmap(scale float32) BVHHeatmap {
+	return BVHHeatmap{max(scale, 1)}
+}
+
+func (h BVHHeatmap) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	var stats traversalStats
+	if scene.accel != nil {
+		scene.accel.traverse(ray, &stats)
+	} else {
+		stats.tests = len(scene.objects)
+	}
+	return heatColor(float32(stats.nodes+stats.tests) / h.scale)
+}
+
+// heatColor : rampe bleu, cyan, vert, jaune, rouge sur [0, 1].
+func heatColor(v float32) Vec3f {
+	v = clamp32(v, 0, 1) * 4
+	switch {
+	case v < 1:
+		return Vec3f{0, v, 1}
+	case v < 2:
+		return Vec3f{0, 1, 2 - v}
+	case v < 3:
+		return Vec3f{v - 2, 1, 0}
+	}
+	return Vec3f{1, 4 - v, 0}
+}
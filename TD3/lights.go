@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+)
+
+// ------------------------------
+// Toutes les sources de lumière, ponctuelles ou surfaciques, sont vues par
+// les matériaux à travers Scene.sampleLights.
+type LightSource interface {
+	// sampleFrom tire un point de la source vu depuis p : direction
+	// normalisée vers la source, éclairage reçu (densité comprise) et
+	// distance de la source, infinie pour une source à l'infini.
+	sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool)
+	// sampleCount : nombre d'échantillons à tirer quand on en demande n
+	// par source.
+	sampleCount(n int) int
+}
+
+// Light : source ponctuelle historique, sans atténuation.
+type Light struct {
+	color    Vec3f
+	position Vec3f
+}
+
+func (l Light) sampleCount(n int) int { return 1 }
+
+func (l Light) sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool) {
+	d := Add(l.position, p.inverte())
+	return d.normalized(), l.color, d.norme(), true
+}
+
+// Attenuation : l'éclairage est divisé par constant + linear·d + quadratic·d².
+type Attenuation struct {
+	constant, linear, quadratic float32
+}
+
+// InverseSquare : atténuation physique d'une source ponctuelle
+var InverseSquare = Attenuation{0, 0, 1}
+
+func (a Attenuation) at(distance float32) float32 {
+	f := a.constant + a.linear*distance + a.quadratic*distance*distance
+	if f <= 0 {
+		return 1
+	}
+	return 1 / f
+}
+
+// PointLight : source ponctuelle d'intensité intensity (en candelas, la
+// couleur étant normalisée), atténuée selon attenuation.
+type PointLight struct {
+	position, color Vec3f
+	intensity       float32
+	attenuation     Attenuation
+}
+
+func NewPointLight(position, color Vec3f, intensity float32) PointLight {
+	return PointLight{position, color, intensity, InverseSquare}
+}
+
+func (l PointLight) sampleCount(n int) int { return 1 }
+
+func (l PointLight) sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool) {
+	d := Add(l.position, p.inverte())
+	distance := d.norme()
+	return d.normalized(), l.color.mul(l.intensity * l.attenuation.at(distance)), distance, true
+}
+
+// SpotLight : source ponctuelle limitée à un cône autour de direction.
+// L'intensité est pleine jusqu'au demi-angle inner, nulle au-delà de outer
+// (en radians), et la transition est élevée à la puissance falloff.
+type SpotLight struct {
+	position, direction, color Vec3f
+	intensity                  float32
+	inner, outer, falloff      float32
+	attenuation                Attenuation
+}
+
+func NewSpotLight(position, direction, color Vec3f, intensity, inner, outer float32) SpotLight {
+	return SpotLight{position, direction.normalized(), color, intensity, inner, outer, 1, InverseSquare}
+}
+
+func (l SpotLight) sampleCount(n int) int { return 1 }
+
+func (l SpotLight) cone(cos float32) float32 {
+	cosInner := float32(math.Cos(float64(l.inner)))
+	cosOuter := float32(math.Cos(float64(l.outer)))
+	t := smoothstep(cosOuter, cosInner, cos)
+	if l.falloff != 1 && t > 0 {
+		t = float32(math.Pow(float64(t), float64(l.falloff)))
+	}
+	return t
+}
+
+func (l SpotLight) sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool) {
+	d := Add(l.position, p.inverte())
+	distance := d.norme()
+	wi := d.normalized()
+	cone := l.cone(-Dot(wi, l.direction))
+	if cone <= 0 {
+		return Vec3f{}, Vec3f{}, 0, false
+	}
+	return wi, l.color.mul(l.intensity * cone * l.attenuation.at(distance)), distance, true
+}
+
+// DirectionalLight : source à l'infini (soleil) éclairant selon direction,
+// d'éclairement illuminance (en lux) sur une surface qui lui fait face.
+type DirectionalLight struct {
+	direction, color Vec3f
+	illuminance      float32
+}
+
+func NewDirectionalLight(direction, color Vec3f, illuminance float32) DirectionalLight {
+	return DirectionalLight{direction.normalized(), color, illuminance}
+}
+
+func (l DirectionalLight) sampleCount(n int) int { return 1 }
+
+func (l DirectionalLight) sampleFrom(p Vec3f, u1, u2 float32) (Vec3f, Vec3f, float32, bool) {
+	return l.direction.inverte(), l.color.mul(l.illuminance), float32(math.Inf(1)), true
+}
+
+// ------------------------------
+// Échantillon d'éclairage direct vu depuis un point de surface
+type lightSample struct {
+	direction Vec3f // normalisée, vers la lumière
+	color     Vec3f // contribution de l'échantillon, densité comprise
+	visible   bool
+	spectrum  Spectrum // spectre de luminance 1 de la source, nil pour color
+}
+
+// positionRng : le rendu direct n'a pas de générateur par pixel, la graine
+// est tirée de la position de l'impact.
+func positionRng(p Vec3f) *Rng {
+	return NewRng(uint64(math.Float32bits(p.x))*0x9e3779b97f4a7c15 ^
+		uint64(math.Float32bits(p.y))*0xbf58476d1ce4e5b9 ^
+		uint64(math.Float32bits(p.z))*0x94d049bb133111eb)
+}
+
+// sampleLights interroge chaque source, ponctuelle ou surfacique, avec
+// s.lightSamples échantillons stratifiés pour celles qui en ont besoin, et
+// lance un rayon d'ombre par échantillon.
+func (s Scene) sampleLights(ray Ray, hit HitRecord, rng *Rng) []lightSample {
+	return s.lightsFrom(hit.position, ray.time, rng)
+}
+
+// lightsFrom échantillonne les sources vues depuis un point quelconque,
+// de surface ou de milieu. Les milieux traversés atténuent les échantillons
+// visibles.
+func (s Scene) lightsFrom(p Vec3f, time float32, rng *Rng) []lightSample {
+	var samples []lightSample
+	requested := max(s.lightSamples, 1)
+	add := func(light LightSource, spectrum Spectrum) {
+		n := light.sampleCount(requested)
+		for i := 0; i < n; i++ {
+			wi, color, distance, ok := light.sampleFrom(p, (float32(i)+rng.float())/float32(n), rng.float())
+			if !ok {
+				continue
+			}
+			visible := !s.occluded(p, wi, distance, time)
+			if visible && len(s.media) > 0 {
+				color = color.mul(s.transmittance(p, wi, distance, time, rng))
+			}
+			samples = append(samples, lightSample{
+				direction: wi,
+				color:     color.mul(1 / float32(n)),
+				visible:   visible,
+				spectrum:  spectrum,
+			})
+		}
+	}
+	for _, light := range s.lights {
+		add(light, nil)
+	}
+	for _, light := range s.areaLights {
+		add(light, light.spectrum)
+	}
+	return samples
+}
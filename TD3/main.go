@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
@@ -10,6 +11,8 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -60,11 +63,31 @@ func (v Vec3f) normalized() Vec3f {
 	return Vec3f{v.x / norme, v.y / norme, v.z / norme}
 }
 
+// --------------------------------
+// Un rayon porte l'instant auquel il est lancé, dans l'intervalle
+// d'obturation de la caméra.
+type Ray struct {
+	origin, direction Vec3f
+	time              float32
+}
+
+func (r Ray) at(t float32) Vec3f {
+	return Add(r.origin, r.direction.mul(t))
+}
+
 // --------------------------------
 type rgbRepresentation struct {
 	r, g, b uint8
 }
 
+// toRGB ramène une radiance linéaire dans [0, 1] puis sur 8 bits.
+func toRGB(c Vec3f) rgbRepresentation {
+	clamp := func(v float32) uint8 {
+		return uint8(max(0, min(v, 1)) * 255)
+	}
+	return rgbRepresentation{clamp(c.x), clamp(c.y), clamp(c.z)}
+}
+
 // --------------------------------
 type Image struct {
 	frameBuffer   []rgbRepresentation
@@ -94,66 +117,140 @@ func (i Image) save(path string) error {
 }
 
 // ------------------
-type Light struct {
-	color    Vec3f
-	position Vec3f
-}
-
 // --------------------------------
 type Scene struct {
-	objects []GeometricObject
-	lights  []Light
+	objects    []GeometricObject
+	lights     []LightSource
+	areaLights []AreaLight
+	// sampled[objectID] : l'objet touché est une source surfacique
+	sampled []bool
+	root    *Node
+	accel   *BVH
+	// Nombre d'échantillons par source surfacique
+	lightSamples int
+	// Fond et éclairage à l'infini, nil pour un fond noir
+	environment Environment
+	// Milieux participants : brouillard et volumes
+	media []Medium
+	// Matériaux distincts des objets, pour la passe d'identifiants
+	materialTable []Materials
 }
 
-func (s *Scene) addLight(l Light) {
+func (s *Scene) addLight(l LightSource) {
 	s.lights = append(s.lights, l)
 }
 func (s *Scene) addElement(g GeometricObject) {
 	s.objects = append(s.objects, g)
 }
 
+// addNode accroche un nœud à la racine du graphe de scène.
+func (s *Scene) addNode(n *Node) *Node {
+	if s.root == nil {
+		s.root = NewNode("")
+	}
+	return s.root.add(n)
+}
+
+func (s *Scene) node(path string) *Node {
+	if s.root == nil {
+		return nil
+	}
+	return s.root.find(path)
+}
+
+// prepared aplatit le graphe de scène avec les objets de s.objects dans une
+// BVH et recense les surfaces émissives. Appelée une fois par image, avant
+// le rendu.
+func (s Scene) prepared() Scene {
+	objects := s.objects
+	if s.root != nil {
+		objects = append(append([]GeometricObject(nil), objects...), s.root.flatten(identity(), nil)...)
+	}
+	s.accel = NewBVH(objects)
+	s.areaLights = nil
+	s.sampled = make([]bool, len(objects)+1)
+	s.materialTable = nil
+	for i, object := range objects {
+		if light, ok := areaLightOf(object); ok {
+			s.areaLights = append(s.areaLights, light)
+			s.sampled[i+1] = true
+		}
+		s.materialTable = collectMaterials(object, s.materialTable)
+	}
+	return s
+}
+
+// sampledEmitter indique si la surface touchée est échantillonnée comme
+// source surfacique. Sinon (boîte, CSG, objet animé...) son émission n'est
+// atteinte que par les chemins et doit être comptée à chaque rebond.
+func (s Scene) sampledEmitter(hit HitRecord) bool {
+	return hit.objectID < len(s.sampled) && s.sampled[hit.objectID]
+}
+
+func (s Scene) closest(ray Ray) (HitRecord, bool) {
+	if s.accel != nil {
+		return s.accel.closest(ray)
+	}
+	hit, index, found := closestIndex(s.objects, ray, hitEpsilon, float32(math.MaxFloat32))
+	hit.objectID = index + 1
+	return hit, found
+}
+
+// occluded indique si un objet coupe le segment partant de from dans la
+// direction normalisée direction, sur distance (rayon d'ombre). La marge
+// évite de compter la surface de la source elle-même.
+func (s Scene) occluded(from, direction Vec3f, distance, time float32) bool {
+	hit, found := s.closest(Ray{from, direction, time})
+	return found && hit.t < distance*(1-shadowEpsilon)
+}
+
+const shadowEpsilon = 1e-3
+
+// Chaque paramètre peut être modulé par une texture (kaMap...) ; pour n,
+// seule la première composante de la texture compte.
 type Phong struct {
 	ka Vec3f
 	kd Vec3f
 	ks Vec3f
 	n  float32
+
+	kaMap, kdMap, ksMap, nMap Texture
 }
 
-func (p Phong) render(rio, rdi, normal Vec3f, t float32, scene Scene) rgbRepresentation {
-	hitPoint := Add(rio, rdi.mul(t))
+func (p Phong) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	ka := modulate(p.ka, p.kaMap, hit)
+	kd := modulate(p.kd, p.kdMap, hit)
+	ks := modulate(p.ks, p.ksMap, hit)
+	n := modulate(Vec3f{p.n, p.n, p.n}, p.nMap, hit).x
+	normal := hit.shadingNormal
 	var finalColor Vec3f = Vec3f{0, 0, 0}
 
-	for _, light := range scene.lights {
-		lightDir := Add(light.position, hitPoint.inverte())
-		lightDir.normalize()
-		viewDir := rio.inverte().normalized()
-		ambient := Mul(p.ka, light.color)
+	for _, light := range scene.sampleLights(ray, hit, positionRng(hit.position)) {
+		lightDir := light.direction
+		viewDir := ray.direction.inverte().normalized()
+		ambient := Mul(ka, light.color)
+		if !light.visible {
+			finalColor = Add(finalColor, ambient)
+			continue
+		}
 		diffuseFactor := Dot(normal, lightDir)
 		if diffuseFactor < 0 {
 			diffuseFactor = 0
 		}
-		diffuse := Mul(p.kd, light.color.mul(diffuseFactor))
+		diffuse := Mul(kd, light.color.mul(diffuseFactor))
 		reflectDir := Add(lightDir.inverte(), normal.mul(2*Dot(normal, lightDir)))
 		reflectDir.normalize()
 		specularFactor := Dot(reflectDir, viewDir)
 		if specularFactor < 0 {
 			specularFactor = 0
 		}
-		specularFactor = float32(math.Pow(float64(specularFactor), float64(p.n)))
-		specular := Mul(p.ks, light.color.mul(specularFactor))
+		specularFactor = float32(math.Pow(float64(specularFactor), float64(n)))
+		specular := Mul(ks, light.color.mul(specularFactor))
 		lightContribution := Add(ambient, Add(diffuse, specular))
 		finalColor = Add(finalColor, lightContribution)
 	}
 
-	finalColor.x = float32(math.Min(float64(finalColor.x), 1.0))
-	finalColor.y = float32(math.Min(float64(finalColor.y), 1.0))
-	finalColor.z = float32(math.Min(float64(finalColor.z), 1.0))
-
-	return rgbRepresentation{
-		r: uint8(finalColor.x * 255),
-		g: uint8(finalColor.y * 255),
-		b: uint8(finalColor.z * 255),
-	}
+	return finalColor
 }
 
 type RenderJob struct {
@@ -162,12 +259,67 @@ type RenderJob struct {
 	Width, Height int
 	Camera        Camera
 	Scene         Scene
+	Frame         int
+	Options       RenderOptions
+	Weight        float32 // part du rendu portée par le job, 0 s'il est seul sur sa tuile
+}
+
+// RenderOptions : réglages d'un rendu qui voyagent avec les jobs.
+type RenderOptions struct {
+	AOVs      AOVSet  // passes à écrire
+	AOVFormat string  // "png" ou "exr"
+	Denoise   float32 // force du débruitage, 0 pour aucun
+	Adaptive  AdaptiveSampling
+	Budget    time.Duration // temps d'affinage de chaque image, 0 pour aucun
+	// Intégrateur qui estime la radiance de chaque rayon de caméra
+	Integrator Integrator
+	// Mêlée à la graine de chaque pixel : deux jobs qui rendent les mêmes
+	// pixels avec des graines différentes tracent des chemins différents
+	Seed uint64
+}
+
+// passes ajoute aux passes demandées les guides du débruiteur.
+func (o RenderOptions) passes() AOVSet {
+	if o.Denoise <= 0 {
+		return o.AOVs
+	}
+	list := strings.Join(append(AOVSet{AOVDepth, AOVNormal, AOVAlbedo}, o.AOVs...), ",")
+	passes, _ := parseAOVs(list)
+	return passes
+}
+
+// finish débruite une image entière si demandé, met à jour ses pixels et
+// ne garde que les passes demandées.
+func (o RenderOptions) finish(image Image, layers []AOVLayer) []AOVLayer {
+	if o.Denoise > 0 && len(layers) > 0 {
+		denoised := denoise(layers, image.width, image.height, o.Denoise)
+		for i, c := range denoised {
+			image.frameBuffer[i] = toRGB(c)
+			layers[0].set(i, c)
+		}
+	}
+	if len(o.AOVs) == 0 {
+		return nil
+	}
+	var kept []AOVLayer
+	for _, l := range layers {
+		guide := l.Name == AOVDepth || l.Name == AOVNormal || l.Name == AOVAlbedo
+		if !guide || o.AOVs.has(l.Name) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
 }
 
 type RenderResult struct {
 	StartX, StartY int
 	Width, Height  int
+	Frame          int
 	Pixels         []rgbRepresentation
+	Layers         []AOVLayer
+	Samples        int // échantillons lancés dans la tuile
+	Weight         float32
+	Radiance       []Vec3f // à la place de Pixels quand Weight > 0
 }
 
 type TCPServer struct {
@@ -180,8 +332,21 @@ type TCPServer struct {
 	clientsMutex     sync.Mutex
 	frameBuffer      []rgbRepresentation
 	completedJobs    int
+	samples          int
 	totalJobs        int
 	completedJobsMux sync.Mutex
+
+	// Mode animation : chaque client reçoit des images entières
+	animation             *Animation
+	firstFrame, lastFrame int
+	framePattern          string
+
+	// Passes AOV, assemblées comme l'image finale
+	options RenderOptions
+	layers  []AOVLayer
+
+	// Somme pondérée des radiances quand les jobs se partagent l'image
+	radiance []Vec3f
 }
 
 func NewTCPServer(address string, scene Scene, camera Camera, width, height int) *TCPServer {
@@ -195,6 +360,17 @@ func NewTCPServer(address string, scene Scene, camera Camera, width, height int)
 	}
 }
 
+func (s *TCPServer) setOptions(options RenderOptions) {
+	s.options = options
+}
+
+func (s *TCPServer) setAnimation(animation Animation, first, last int, pattern string) {
+	s.animation = &animation
+	s.firstFrame = first
+	s.lastFrame = last
+	s.framePattern = pattern
+}
+
 func (s *TCPServer) Start() error {
 	listener, err := net.Listen("tcp", s.address)
 	if err != nil {
@@ -213,15 +389,25 @@ func (s *TCPServer) Start() error {
 
 	s.distributeJobs()
 	s.waitForCompletion()
+	s.resolveRadiance()
+
+	if s.animation != nil {
+		fmt.Printf("Rendering complete! Frames %d to %d saved\n", s.firstFrame, s.lastFrame)
+	} else {
+		img := Image{s.frameBuffer, s.imageWidth, s.imageHeight}
+		s.layers = s.options.finish(img, s.layers)
+		err = img.save("distributed_result.png")
+		if err != nil {
+			return fmt.Errorf("failed to save image: %v", err)
+		}
+		err = saveAOVs("distributed_result", s.layers, s.imageWidth, s.imageHeight, s.options.AOVFormat)
+		if err != nil {
+			return fmt.Errorf("failed to save AOVs: %v", err)
+		}
 
-	img := Image{s.frameBuffer, s.imageWidth, s.imageHeight}
-	err = img.save("distributed_result.png")
-	if err != nil {
-		return fmt.Errorf("failed to save image: %v", err)
+		fmt.Printf("Rendering complete! Image saved as distributed_result.png (%.1f samples per pixel)\n", samplesPerPixel(s.samples, s.imageWidth, s.imageHeight))
 	}
 
-	fmt.Println("Rendering complete! Image saved as distributed_result.png")
-
 	s.clientsMutex.Lock()
 	for _, client := range s.clients {
 		client.Close()
@@ -280,16 +466,49 @@ func (s *TCPServer) handleClient(conn net.Conn) {
 }
 
 func (s *TCPServer) distributeJobs() {
+	if s.animation != nil {
+		s.distributeFrames()
+		return
+	}
+
 	s.clientsMutex.Lock()
 	numClients := len(s.clients)
 	s.clientsMutex.Unlock()
 
 	if numClients == 0 {
 		fmt.Println("No clients connected. Rendering locally...")
-		renderFrame(Image{s.frameBuffer, s.imageWidth, s.imageHeight}, s.camera, s.scene)
+		// Start débruite et enregistre l'image comme si elle venait des clients
+		var pixels []rgbRepresentation
+		pixels, s.layers, s.samples = renderTile(s.scene.prepared(), s.camera, 0, 0, s.imageWidth, s.imageHeight, s.imageWidth, s.imageHeight, s.options)
+		copy(s.frameBuffer, pixels)
 		return
 	}
 
+	var jobs []RenderJob
+	if ppm, ok := s.options.Integrator.(ProgressivePhotonMapper); ok && numClients > 1 && ppm.passes > 1 {
+		jobs = s.passJobs(ppm, numClients)
+	} else {
+		jobs = s.bandJobs(numClients)
+	}
+
+	s.totalJobs = len(jobs)
+	fmt.Printf("Distributing %d jobs to %d clients\n", s.totalJobs, numClients)
+
+	s.clientsMutex.Lock()
+	for i, client := range s.clients {
+		if i < len(jobs) {
+			encoder := gob.NewEncoder(client)
+			err := encoder.Encode(jobs[i])
+			if err != nil {
+				fmt.Printf("Error sending job to client: %v\n", err)
+			}
+		}
+	}
+	s.clientsMutex.Unlock()
+}
+
+// bandJobs découpe l'image en bandes horizontales, une par client.
+func (s *TCPServer) bandJobs(numClients int) []RenderJob {
 	rowsPerClient := s.imageHeight / numClients
 
 	var jobs []RenderJob
@@ -303,36 +522,103 @@ func (s *TCPServer) distributeJobs() {
 		}
 
 		job := RenderJob{
-			StartX: 0,
-			EndX:   s.imageWidth,
-			StartY: startY,
-			EndY:   endY,
-			Width:  s.imageWidth,
-			Height: s.imageHeight,
-			Camera: s.camera,
-			Scene:  s.scene,
+			StartX:  0,
+			EndX:    s.imageWidth,
+			StartY:  startY,
+			EndY:    endY,
+			Width:   s.imageWidth,
+			Height:  s.imageHeight,
+			Camera:  s.camera,
+			Scene:   s.scene,
+			Options: s.options,
 		}
 
 		jobs = append(jobs, job)
 	}
+	return jobs
+}
+
+// passJobs confie l'image entière à chaque client avec une plage des passes
+// de photons : chacun ne trace que les siennes, le serveur fait la moyenne.
+func (s *TCPServer) passJobs(ppm ProgressivePhotonMapper, numClients int) []RenderJob {
+	var jobs []RenderJob
+	for _, part := range ppm.split(numClients) {
+		options := s.options
+		options.Integrator = part
+		options.Seed = uint64(part.firstPass)
+		jobs = append(jobs, RenderJob{
+			EndX:    s.imageWidth,
+			EndY:    s.imageHeight,
+			Width:   s.imageWidth,
+			Height:  s.imageHeight,
+			Camera:  s.camera,
+			Scene:   s.scene,
+			Options: options,
+			Weight:  float32(part.passes) / float32(ppm.passes),
+		})
+	}
+	return jobs
+}
+
+// distributeFrames envoie des images entières aux clients, à tour de rôle.
+func (s *TCPServer) distributeFrames() {
+	s.clientsMutex.Lock()
+	numClients := len(s.clients)
+	s.clientsMutex.Unlock()
+
+	if numClients == 0 {
+		fmt.Println("No clients connected. Rendering frames locally...")
+		err := renderSequence(s.scene, s.camera, *s.animation, s.firstFrame, s.lastFrame, s.imageWidth, s.imageHeight, s.framePattern, s.options)
+		if err != nil {
+			fmt.Printf("Error rendering frames: %v\n", err)
+		}
+		return
+	}
+
+	var jobs []RenderJob
+	for frame := s.firstFrame; frame <= s.lastFrame; frame++ {
+		frameScene, frameCamera := s.animation.evaluate(s.scene, s.camera, s.animation.frameTime(frame))
+		jobs = append(jobs, RenderJob{
+			StartX:  0,
+			EndX:    s.imageWidth,
+			StartY:  0,
+			EndY:    s.imageHeight,
+			Width:   s.imageWidth,
+			Height:  s.imageHeight,
+			Camera:  frameCamera,
+			Scene:   frameScene,
+			Frame:   frame,
+			Options: s.options,
+		})
+	}
 
 	s.totalJobs = len(jobs)
-	fmt.Printf("Distributing %d jobs to %d clients\n", s.totalJobs, numClients)
+	fmt.Printf("Distributing %d frames to %d clients\n", s.totalJobs, numClients)
 
 	s.clientsMutex.Lock()
+	// Un seul encodeur par client : le décodeur du client est partagé entre les jobs
+	encoders := make([]*gob.Encoder, len(s.clients))
 	for i, client := range s.clients {
-		if i < len(jobs) {
-			encoder := gob.NewEncoder(client)
-			err := encoder.Encode(jobs[i])
-			if err != nil {
-				fmt.Printf("Error sending job to client: %v\n", err)
-			}
+		encoders[i] = gob.NewEncoder(client)
+	}
+	for i, job := range jobs {
+		err := encoders[i%len(encoders)].Encode(job)
+		if err != nil {
+			fmt.Printf("Error sending frame %d to client: %v\n", job.Frame, err)
 		}
 	}
 	s.clientsMutex.Unlock()
 }
 
 func (s *TCPServer) processResult(result RenderResult) {
+	if s.animation != nil {
+		s.processFrame(result)
+		return
+	}
+
+	if result.Weight > 0 {
+		s.accumulateRadiance(result)
+	}
 	for y := 0; y < result.Height; y++ {
 		for x := 0; x < result.Width; x++ {
 			globalX := result.StartX + x
@@ -348,9 +634,15 @@ func (s *TCPServer) processResult(result RenderResult) {
 			}
 		}
 	}
+	if len(result.Layers) > 0 {
+		s.completedJobsMux.Lock()
+		s.layers = pasteLayers(s.layers, result.Layers, result.StartX, result.StartY, result.Width, s.imageWidth, s.imageHeight, result.Weight)
+		s.completedJobsMux.Unlock()
+	}
 
 	s.completedJobsMux.Lock()
 	s.completedJobs++
+	s.samples += result.Samples
 	completed := s.completedJobs
 	total := s.totalJobs
 	s.completedJobsMux.Unlock()
@@ -358,6 +650,54 @@ func (s *TCPServer) processResult(result RenderResult) {
 	fmt.Printf("Received results: %d/%d jobs completed\n", completed, total)
 }
 
+// accumulateRadiance ajoute la radiance d'un job, pondérée par sa part.
+func (s *TCPServer) accumulateRadiance(result RenderResult) {
+	s.completedJobsMux.Lock()
+	defer s.completedJobsMux.Unlock()
+	if s.radiance == nil {
+		s.radiance = make([]Vec3f, s.imageWidth*s.imageHeight)
+	}
+	for i, c := range result.Radiance {
+		x, y := result.StartX+i%result.Width, result.StartY+i/result.Width
+		if x < s.imageWidth && y < s.imageHeight {
+			index := y*s.imageWidth + x
+			s.radiance[index] = Add(s.radiance[index], c.mul(result.Weight))
+		}
+	}
+}
+
+// resolveRadiance convertit la moyenne des jobs en pixels ; les passes ont
+// été moyennées de la même façon par pasteLayers.
+func (s *TCPServer) resolveRadiance() {
+	if s.radiance == nil {
+		return
+	}
+	for i, c := range s.radiance {
+		s.frameBuffer[i] = toRGB(c)
+	}
+}
+
+func (s *TCPServer) processFrame(result RenderResult) {
+	img := Image{result.Pixels, result.Width, result.Height}
+	layers := s.options.finish(img, result.Layers)
+	path := fmt.Sprintf(s.framePattern, result.Frame)
+	err := img.save(path)
+	if err == nil {
+		err = saveAOVs(strings.TrimSuffix(path, filepath.Ext(path)), layers, result.Width, result.Height, s.options.AOVFormat)
+	}
+	if err != nil {
+		fmt.Printf("Error saving frame %d: %v\n", result.Frame, err)
+	}
+
+	s.completedJobsMux.Lock()
+	s.completedJobs++
+	completed := s.completedJobs
+	total := s.totalJobs
+	s.completedJobsMux.Unlock()
+
+	fmt.Printf("Received frame %d (%.1f samples per pixel): %d/%d frames completed\n", result.Frame, samplesPerPixel(result.Samples, result.Width, result.Height), completed, total)
+}
+
 func (s *TCPServer) waitForCompletion() {
 	for {
 		s.completedJobsMux.Lock()
@@ -442,39 +782,30 @@ func (c *TCPClient) renderWorker(id int, jobs <-chan RenderJob, results chan<- R
 
 	for job := range jobs {
 		fmt.Printf("Worker %d processing job...\n", id)
+		scene := job.Scene.prepared()
 
 		width := job.EndX - job.StartX
 		height := job.EndY - job.StartY
 
-		pixels := make([]rgbRepresentation, width*height)
-
-		ro := job.Camera.position
-		cosFovy := float32(0.66)
-
-		aspect := float32(job.Width) / float32(job.Height)
-		horizontal := (cross(job.Camera.direction(), job.Camera.up)).normalized().mul(cosFovy * aspect)
-		vertical := (cross(horizontal, job.Camera.direction())).normalized().mul(cosFovy)
-
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				globalX := job.StartX + x
-				globalY := job.StartY + y
-
-				uvx := (float32(globalX) + float32(0.5)) / float32(job.Width)
-				uvy := (float32(globalY) + float32(0.5)) / float32(job.Height)
-
-				rd := Add(Add(job.Camera.direction(), horizontal.mul(uvx-float32(0.5))), vertical.mul(uvy-float32(0.5))).normalized()
-
-				pixels[y*width+x] = renderPixel(job.Scene, ro, rd)
-			}
-		}
+		radiance, layers, samples := renderTileRadiance(scene, job.Camera, job.StartX, job.StartY, job.EndX, job.EndY, job.Width, job.Height, job.Options)
 
 		result := RenderResult{
-			StartX: job.StartX,
-			StartY: job.StartY,
-			Width:  width,
-			Height: height,
-			Pixels: pixels,
+			StartX:  job.StartX,
+			StartY:  job.StartY,
+			Width:   width,
+			Height:  height,
+			Frame:   job.Frame,
+			Layers:  layers,
+			Samples: samples,
+			Weight:  job.Weight,
+		}
+		if job.Weight > 0 {
+			result.Radiance = radiance
+		} else {
+			result.Pixels = make([]rgbRepresentation, len(radiance))
+			for i, c := range radiance {
+				result.Pixels[i] = toRGB(c)
+			}
 		}
 
 		results <- result
@@ -490,15 +821,71 @@ func init() {
 	gob.Register(Sphere{})
 	gob.Register(Lambert{})
 	gob.Register(Phong{})
+	gob.Register(Moving{})
+	gob.Register(LinearMotion{})
+	gob.Register(KeyframedMotion{})
+	gob.Register(Transformed{})
+	gob.Register(&Mesh{})
+	gob.Register(meshRank(0))
+	gob.Register(Plane{})
+	gob.Register(Disk{})
+	gob.Register(Box{})
+	gob.Register(OrientedBox{})
+	gob.Register(Cylinder{})
+	gob.Register(Cone{})
+	gob.Register(Torus{})
+	gob.Register(CSG{})
+	gob.Register(SDFObject{})
+	gob.Register(SDFSphere{})
+	gob.Register(SDFBox{})
+	gob.Register(SDFRoundBox{})
+	gob.Register(SDFTorus{})
+	gob.Register(SDFCapsule{})
+	gob.Register(SmoothSDF{})
+	gob.Register(Repeat{})
+	gob.Register(Twist{})
+	gob.Register(Heightfield{})
+	gob.Register(TerrainMaterial{})
+	gob.Register(CheckerTexture{})
+	gob.Register(ImageTexture{})
+	gob.Register(NoiseTexture{})
+	gob.Register(NormalMapped{})
+	gob.Register(CookTorrance{})
+	gob.Register(DirectLighting{})
+	gob.Register(PathTracer{})
+	gob.Register(AmbientOcclusion{})
+	gob.Register(NormalShading{})
+	gob.Register(UVShading{})
+	gob.Register(DepthShading{})
+	gob.Register(FacingRatio{})
+	gob.Register(BVHHeatmap{})
+	gob.Register(Emissive{})
+	gob.Register(Toon{})
+	gob.Register(Dielectric{})
+	gob.Register(Subsurface{})
+	gob.Register(SpectralPathTracer{})
+	gob.Register(TabulatedSpectrum{})
+	gob.Register(PhotonMapper{})
+	gob.Register(ProgressivePhotonMapper{})
+	gob.Register(Rectangle{})
+	gob.Register(PointLight{})
+	gob.Register(SpotLight{})
+	gob.Register(DirectionalLight{})
+	gob.Register(ConstantEnvironment{})
+	gob.Register(GradientEnvironment{})
+	gob.Register(SunSky{})
+	gob.Register(EnvironmentMap{})
+	gob.Register(Fog{})
+	gob.Register(HomogeneousVolume{})
+	gob.Register(GridVolume{})
 }
 
-func serverMain() {
-	scene := Scene{}
-	populateSceneWithPhong(&scene)
-
-	camera := Camera{Vec3f{0, 0, -5}, Vec3f{0, 1, 0}, Vec3f{0, 0, 5}}
-
+func serverMain(scene Scene, camera Camera, frames frameRange, options RenderOptions) {
 	server := NewTCPServer(":8081", scene, camera, 2048, 2048)
+	server.setOptions(options)
+	if frames.set {
+		server.setAnimation(populateAnimation(frames.fps), frames.first, frames.last, frames.pattern)
+	}
 
 	err := server.Start()
 	if err != nil {
@@ -533,14 +920,17 @@ func NewPhongMaterial(diffuseColor Vec3f, specularStrength float32, shininess fl
 
 func populateSceneWithPhong(scene *Scene) {
 	scene.addElement(Sphere{1, Vec3f{0, 0, 8}, NewPhongMaterial(Vec3f{1.0, 0, 0}, 0.8, 32)})
-	scene.addElement(Sphere{0.3, Vec3f{2, 1.5, 4}, NewPhongMaterial(Vec3f{0.0, 1.0, 0}, 0.5, 16)})
-	scene.addElement(Sphere{0.9, Vec3f{0, -1, 5}, Lambert{Vec3f{0.0, 0, 1.0}}})
+	scene.addElement(Moving{Sphere{0.3, Vec3f{2, 1.5, 4}, NewPhongMaterial(Vec3f{0.0, 1.0, 0}, 0.5, 16)}, LinearMotion{Vec3f{12, 0, 0}}})
+	scene.addElement(Sphere{0.9, Vec3f{0, -1, 5}, Lambert{kd: Vec3f{0.0, 0, 1.0}}})
 	scene.addElement(Sphere{0.5, Vec3f{-2, -2, 5}, NewPhongMaterial(Vec3f{1.0, 1.0, 1.0}, 0.9, 64)})
+	scene.addElement(NewPlane(Vec3f{0, -6, 0}, Vec3f{0, 1, 0}, NewPhongMaterial(Vec3f{0.6, 0.6, 0.6}, 0.1, 8)))
 
 	randomSpheres := generateRandomSpheresWithMixedMaterials(15, 0.2, 0.7, Vec3f{5, 5, 10})
 	for _, sphere := range randomSpheres {
 		scene.addElement(sphere)
 	}
+	// Forêt d'instances d'un seul maillage, au sol derrière les sphères
+	populateForest(scene, 500, Vec3f{0, -6, 30}, 20)
 
 	scene.addLight(Light{Vec3f{1.0, 1.0, 1.0}, Vec3f{0, 10, 0}})
 	scene.addLight(Light{Vec3f{0.5, 0.5, 0.8}, Vec3f{-10, 5, -5}})
@@ -582,26 +972,64 @@ func generateRandomSpheresWithMixedMaterials(count int, minRadius, maxRadius flo
 }
 
 // ----------------------------------
+// Les matériaux renvoient une radiance linéaire, ramenée sur 8 bits par toRGB.
 type Materials interface {
-	render(rio, rdi, n Vec3f, t float32, scene Scene) rgbRepresentation
+	render(ray Ray, hit HitRecord, scene Scene) Vec3f
 }
 
 type Lambert struct {
-	kd Vec3f
+	kd    Vec3f
+	kdMap Texture
+	// Réflectance tabulée pour le rendu spectral ; kd est alors sa couleur
+	spectrum Spectrum
 }
 
-func (l Lambert) render(rio, rdi, n Vec3f, t float32, scene Scene) rgbRepresentation {
+func (l Lambert) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
 	// res := Mul(l.kd, scene.lights[0].color) // res := l.kd
 	// return rgbRepresentation{uint8(res.x), uint8(res.y), uint8(res.z)}
-	omega := Add(rio, rdi.mul(t))
-	omega = Add(scene.lights[0].position, Vec3f{-omega.x, -omega.y, -omega.z})
-	Li := Mul(l.kd, scene.lights[0].color.mul(Dot(n, omega))).mul(1 / 3.14)
-	return rgbRepresentation{uint8(Li.x * 255), uint8(Li.y * 255), uint8(Li.z * 255)}
+	return directLighting(l, ray, hit, scene.sampleLights(ray, hit, positionRng(hit.position)))
+}
+
+// Résultat d'une intersection. geometricNormal est la normale sortante de la
+// surface ; shadingNormal est tournée vers l'origine du rayon et sert à
+// l'éclairage. tangent suit la direction des u croissants (dp/du), sans être
+// forcément unitaire ni orthogonale à la normale.
+type HitRecord struct {
+	t               float32
+	position        Vec3f
+	geometricNormal Vec3f
+	shadingNormal   Vec3f
+	uv              Vec2f
+	tangent         Vec3f
+	frontFace       bool
+	material        Materials
+	objectID        int // rang de l'objet dans la scène préparée plus un, 0 si inconnu
+}
+
+// newHit choisit une tangente arbitraire ; les objets qui connaissent leur
+// paramétrisation la remplacent.
+func newHit(ray Ray, t float32, outward Vec3f, uv Vec2f, material Materials) HitRecord {
+	tangent, _ := orthonormalBasis(outward)
+	hit := HitRecord{
+		t:               t,
+		position:        ray.at(t),
+		geometricNormal: outward,
+		shadingNormal:   outward,
+		uv:              uv,
+		tangent:         tangent,
+		frontFace:       Dot(ray.direction, outward) < 0,
+		material:        material,
+	}
+	if !hit.frontFace {
+		hit.shadingNormal = outward.inverte()
+	}
+	return hit
 }
 
+// intersect renvoie l'impact le plus proche avec tmin < t < tmax.
 type GeometricObject interface {
-	isIntersectedByRay(ro, rd Vec3f) (bool, float32)
-	render(rio, rdi Vec3f, t float32, scene Scene) rgbRepresentation
+	intersect(ray Ray, tmin, tmax float32) (HitRecord, bool)
+	bounds() Bounds
 }
 
 // -------------------------------
@@ -611,35 +1039,63 @@ type Sphere struct {
 	Material Materials
 }
 
-func (s Sphere) render(rio, rdi Vec3f, t float32, scene Scene) rgbRepresentation {
-	/*
-	* Le calcul de la normal sur une sphère est l'inverse du rayon incident.
-	* C'est pourquoi n = rd1.inverte()
-	 */
-	return s.Material.render(rio, rdi, rdi.inverte(), t, scene)
+func (s Sphere) bounds() Bounds {
+	r := Vec3f{s.radius, s.radius, s.radius}
+	return Bounds{Add(s.position, r.inverte()), Add(s.position, r)}
+}
+
+func (s Sphere) withMaterial(m Materials) GeometricObject {
+	s.Material = m
+	return s
+}
+
+func (s Sphere) material() Materials {
+	return s.Material
 }
-func (s Sphere) isIntersectedByRay(ro, rd Vec3f) (bool, float32) {
-	L := Add(ro, Vec3f{-s.position.x, -s.position.y, -s.position.z})
+
+func (s Sphere) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	rd := ray.direction
+	L := Add(ray.origin, Vec3f{-s.position.x, -s.position.y, -s.position.z})
 
 	a := Dot(rd, rd)
 	b := 2.0 * Dot(rd, L)
 	c := Dot(L, L) - s.radius*s.radius
 	delta := b*b - 4.0*a*c
+	if delta <= 0 {
+		return HitRecord{}, false
+	}
 
-	t0 := (-b - float32(math.Sqrt(float64(delta)))) / 2 * a
-	t1 := (-b + float32(math.Sqrt(float64(delta)))) / 2 * a
-	t := t0
-	t = min(t, t1)
-
-	if delta > 0 {
-		return true, t
+	// La direction n'est pas forcément normalisée (rayons transformés) : a != 1
+	t0 := (-b - float32(math.Sqrt(float64(delta)))) / (2 * a)
+	t1 := (-b + float32(math.Sqrt(float64(delta)))) / (2 * a)
+	ok, t := nearestRoot(tmin, tmax, t0, t1)
+	if !ok {
+		return HitRecord{}, false
 	}
-	return false, 0.0
+
+	// La normale sortante est portée par le rayon de la sphère
+	n := Add(ray.at(t), s.position.inverte()).mul(1 / s.radius)
+	u := math.Atan2(float64(n.z), float64(n.x))/(2*math.Pi) + 0.5
+	v := math.Acos(float64(max(-1, min(n.y, 1)))) / math.Pi
+	hit := newHit(ray, t, n, Vec2f{float32(u), float32(v)}, s.Material)
+	hit.tangent = Vec3f{-n.z, 0, n.x}
+	return hit, true
 }
 
 // ------------------------------
+// Intervalle d'obturation, en secondes depuis le début de l'image
+type Shutter struct {
+	open, close float32
+}
+
 type Camera struct {
 	position, up, at Vec3f
+	shutter          Shutter
+	samples          int
+}
+
+func NewCamera(position, up, at Vec3f) Camera {
+	return Camera{position: position, up: up, at: at, samples: 1}
 }
 
 func (c Camera) direction() Vec3f {
@@ -647,6 +1103,29 @@ func (c Camera) direction() Vec3f {
 	return dir.mul(float32(1) / dir.norme())
 }
 
+func (c Camera) ray(x, y, width, height int, time float32) Ray {
+	cosFovy := float32(0.66)
+
+	aspect := float32(width) / float32(height)
+	horizontal := (cross(c.direction(), c.up)).normalized().mul(cosFovy * aspect)
+	vertical := (cross(horizontal, c.direction())).normalized().mul(cosFovy)
+
+	uvx := (float32(x) + float32(0.5)) / float32(width)
+	uvy := (float32(y) + float32(0.5)) / float32(height)
+
+	rd := Add(Add(c.direction(), horizontal.mul(uvx-float32(0.5))), vertical.mul(uvy-float32(0.5))).normalized()
+	return Ray{c.position, rd, time}
+}
+
+// sampleTime répartit les échantillons par strates sur l'intervalle d'obturation.
+func (c Camera) sampleTime(sample, samples int) float32 {
+	if c.shutter.close <= c.shutter.open {
+		return c.shutter.open
+	}
+	u := (float32(sample) + rand.Float32()) / float32(samples)
+	return c.shutter.open + u*(c.shutter.close-c.shutter.open)
+}
+
 func generateRandomSpheres(count int, minRadius, maxRadius float32, boundingBox Vec3f) []Sphere {
 	// Seed the random number generator
 	rand.Seed(time.Now().UnixNano())
@@ -678,44 +1157,90 @@ func generateRandomSpheres(count int, minRadius, maxRadius float32, boundingBox
 
 // ------------------------------
 
-func renderPixel(scene Scene, ro, rd Vec3f) rgbRepresentation {
-	var tmin float32
-	tmin = 9999999999.0
-	res := rgbRepresentation{}
-	for _, object := range scene.objects {
-		isIntersected, t := object.isIntersectedByRay(ro, rd)
-		if isIntersected && t < tmin {
-			tmin = t
-			res = object.render(ro, rd, t, scene)
-		}
+// renderPixel éclaire une seule fois, au point d'impact le plus proche,
+// puis ajoute la diffusion simple des milieux traversés.
+func renderPixel(scene Scene, ray Ray, rng *Rng) Vec3f {
+	hit, found := scene.closest(ray)
+	var res Vec3f
+	tmax := float32(math.Inf(1))
+	switch {
+	case !found:
+		res = scene.background(ray)
+	case hit.material != nil:
+		res = hit.material.render(ray, hit, scene)
+		tmax = hit.t
+	default:
+		tmax = hit.t
+	}
+	if len(scene.media) == 0 {
+		return res
 	}
-	return res
+	scattered, T := scene.singleScattering(ray, tmax, rng)
+	return Add(res.mul(T), scattered)
 }
 
-func renderFrame(image Image, camera Camera, scene Scene) {
-	ro := camera.position
-	cosFovy := float32(0.66)
-
-	aspect := float32(image.width) / float32(image.height)
-	horizontal := (cross(camera.direction(), camera.up)).normalized().mul(cosFovy * aspect)
-	vertical := (cross(horizontal, camera.direction())).normalized().mul(cosFovy)
+// pixelRadiance moyenne camera.samples rayons lancés à des instants
+// différents de l'intervalle d'obturation (flou de mouvement), estimés par
+// l'intégrateur des options.
+func pixelRadiance(scene Scene, camera Camera, options RenderOptions, x, y, width, height int) Vec3f {
+	samples := max(camera.samples, 1)
+	rng := pixelRng(x, y, options.Seed)
+
+	var sum Vec3f
+	for i := 0; i < samples; i++ {
+		ray := camera.ray(x, y, width, height, camera.sampleTime(i, samples))
+		sum = Add(sum, options.Integrator.radiance(scene, ray, rng))
+	}
+	return sum.mul(1 / float32(samples))
+}
 
-	for x := 0; x < image.width; x++ {
-		for y := 0; y < image.height; y++ {
+// renderFrame rend l'image entière, débruitée si demandé, et renvoie les
+// passes AOV demandées et le nombre total d'échantillons.
+func renderFrame(image Image, camera Camera, scene Scene, options RenderOptions) ([]AOVLayer, int) {
+	pixels, layers, samples := renderTile(scene.prepared(), camera, 0, 0, image.width, image.height, image.width, image.height, options)
+	copy(image.frameBuffer, pixels)
+	return options.finish(image, layers), samples
+}
 
-			uvx := (float32(x) + float32(0.5)) / float32(image.width)
-			uvy := (float32(y) + float32(0.5)) / float32(image.height)
+// samplesPerPixel : moyenne des échantillons lancés par pixel.
+func samplesPerPixel(samples, width, height int) float64 {
+	return float64(samples) / float64(max(width*height, 1))
+}
 
-			rd := Add(Add(camera.direction(), horizontal.mul(uvx-float32(0.5))), vertical.mul(uvy-float32(0.5))).normalized()
+// renderTile rend les pixels [startX, endX[ × [startY, endY[ d'une image
+// width × height, dans une scène préparée, avec leurs passes AOV et le
+// nombre d'échantillons lancés.
+func renderTile(scene Scene, camera Camera, startX, startY, endX, endY, width, height int, options RenderOptions) ([]rgbRepresentation, []AOVLayer, int) {
+	radiance, layers, samples := renderTileRadiance(scene, camera, startX, startY, endX, endY, width, height, options)
+	pixels := make([]rgbRepresentation, len(radiance))
+	for i, c := range radiance {
+		pixels[i] = toRGB(c)
+	}
+	return pixels, layers, samples
+}
 
-			image.frameBuffer[y*image.width+x] = renderPixel(scene, ro, rd)
+// renderTileRadiance : comme renderTile, mais renvoie la radiance avant
+// conversion, que le serveur peut encore moyenner.
+func renderTileRadiance(scene Scene, camera Camera, startX, startY, endX, endY, width, height int, options RenderOptions) ([]Vec3f, []AOVLayer, int) {
+	if p, ok := options.Integrator.(preparedIntegrator); ok {
+		options.Integrator = p.prepare(scene)
+	}
+	tileWidth := endX - startX
+	radiance, samples := sampleTile(scene, camera, startX, startY, endX, endY, width, height, options)
+	outlineTile(scene, camera, radiance, startX, startY, endX, endY, width, height)
+	aovRenderer := newAOVRenderer(scene, options.passes())
+	layers := aovRenderer.layers(len(radiance))
+	for y := startY; y < endY; y++ {
+		for x := startX; x < endX; x++ {
+			index := (y-startY)*tileWidth + (x - startX)
+			aovRenderer.shade(layers, index, scene, camera, options, x, y, width, height, radiance[index])
 		}
 	}
-
+	return radiance, layers, samples
 }
 
 func populateScene(scene *Scene) {
-	scene.addElement(Sphere{1, Vec3f{0, 0, 8}, Lambert{Vec3f{1.0, 0, 0}}})
+	scene.addElement(Sphere{1, Vec3f{0, 0, 8}, Lambert{kd: Vec3f{1.0, 0, 0}}})
 
 	randomSpheres := generateRandomSpheresWithMixedMaterials(40, 0.2, 0.7, Vec3f{5, 5, 10})
 	for _, sphere := range randomSpheres {
@@ -725,25 +1250,157 @@ func populateScene(scene *Scene) {
 	scene.addLight(Light{Vec3f{1.0, 1.0, 1.0}, Vec3f{0, 10, 0}})
 }
 
-func main() {
+func localMain(scene Scene, camera Camera, width, height int, options RenderOptions) {
+	image := Image{make([]rgbRepresentation, width*height), width, height}
+	layers, samples := renderFrame(image, camera, scene, options)
+	err := image.save("./result.png")
+	if err == nil {
+		err = saveAOVs("./result", layers, width, height, options.AOVFormat)
+	}
+	if err != nil {
+		fmt.Printf("Render error: %v\n", err)
+		return
+	}
+	fmt.Printf("Rendering complete! Image saved as result.png (%.1f samples per pixel)\n", samplesPerPixel(samples, width, height))
+}
 
-	// width := 4096
-	// height := 4096
-	// //Créer un objet Scène
-	// scene := Scene{}
+func animateMain(scene Scene, camera Camera, frames frameRange, width, height int, options RenderOptions) {
+	err := renderSequence(scene, camera, populateAnimation(frames.fps), frames.first, frames.last, width, height, frames.pattern, options)
+	if err != nil {
+		fmt.Printf("Animation error: %v\n", err)
+	}
+}
+
+// Plage d'images à rendre, donnée sous la forme "premiere:derniere"
+type frameRange struct {
+	set         bool
+	first, last int
+	fps         float32
+	pattern     string
+}
+
+func (f *frameRange) String() string {
+	return fmt.Sprintf("%d:%d", f.first, f.last)
+}
+
+func (f *frameRange) Set(value string) error {
+	_, err := fmt.Sscanf(value, "%d:%d", &f.first, &f.last)
+	if err != nil || f.last < f.first {
+		return fmt.Errorf("invalid frame range %q, expected first:last", value)
+	}
+	f.set = true
+	return nil
+}
 
-	// //Initialiser la scène
-	// populateScene(&scene)
-	// //Créer une caméra
-	// camera := Camera{Vec3f{0, 0, -5}, Vec3f{0, 1, 0}, Vec3f{0, 0, 5}}
+func main() {
+	frames := frameRange{fps: 24, pattern: "frame_%04d.png"}
+	mode := flag.String("mode", "", "server, client, local or animate (default: server then client)")
+	sceneFile := flag.String("scene", "", "JSON scene file (default: built-in demo scene)")
+	width := flag.Int("width", 512, "image width for the local and animate modes")
+	height := flag.Int("height", 512, "image height for the local and animate modes")
+	flag.Var(&frames, "frames", "frame range to render, e.g. 0:47")
+	flag.Func("fps", "frames per second of the animation (default 24)", func(value string) error {
+		_, err := fmt.Sscanf(value, "%g", &frames.fps)
+		return err
+	})
+	flag.StringVar(&frames.pattern, "out", frames.pattern, "file name pattern of the rendered frames")
+	samples := flag.Int("samples", 1, "samples per pixel")
+	shutter := flag.Float64("shutter", 0, "shutter interval as a fraction of the frame duration (0 disables motion blur)")
+	integrator := flag.String("integrator", "direct", "direct, path (path tracing), spectral (spectral path tracing), photon (path tracing with a caustics photon map), ppm (progressive photon mapping), ao (ambient occlusion), or a debug view: normals, uv, depth, facing, heatmap")
+	depth := flag.Int("depth", 8, "maximum path length of the path tracer")
+	photons := flag.Int("photons", 200000, "photons emitted for the caustics map (per pass for ppm)")
+	photonNearest := flag.Int("photon-nearest", 50, "photons gathered per caustics estimate")
+	photonRadius := flag.Float64("photon-radius", 0.1, "maximum gather radius (initial radius for ppm)")
+	photonPasses := flag.Int("photon-passes", 16, "photon passes of the progressive photon mapper")
+	aoRadius := flag.Float64("ao-radius", 1, "occlusion distance of the ambient occlusion integrator")
+	aoSamples := flag.Int("ao-samples", 16, "rays per camera sample of the ambient occlusion integrator")
+	depthRange := flag.Float64("depth-range", 20, "distance shown black by the depth integrator")
+	heatmapScale := flag.Float64("heatmap-scale", 64, "BVH nodes plus intersection tests shown red by the heatmap integrator")
+	lightSamples := flag.Int("light-samples", 0, "samples per area light (default: scene setting, or 1)")
+	aovList := flag.String("aov", "", "comma-separated AOV passes: depth, normal, albedo, objectid, materialid, lights, or all")
+	aovFormat := flag.String("aov-format", "png", "png (one file per pass) or exr (multi-layer)")
+	denoiseStrength := flag.Float64("denoise", 0, "denoiser strength, guided by the albedo, normal and depth passes (0 disables, 1 is a good start)")
+	adaptiveThreshold := flag.Float64("adaptive-threshold", 0, "relative noise below which a pixel stops receiving samples (0 disables adaptive sampling)")
+	maxSamples := flag.Int("max-samples", 64, "samples per pixel cap for adaptive sampling")
+	budget := flag.Duration("budget", 0, "wall-clock time to refine each image, e.g. 30s or 5m (0 disables)")
+	flag.Parse()
+
+	aovs, err := parseAOVs(*aovList)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *aovFormat != "png" && *aovFormat != "exr" {
+		fmt.Printf("Unknown AOV format %q\n", *aovFormat)
+		return
+	}
+	options := RenderOptions{
+		AOVs:      aovs,
+		AOVFormat: *aovFormat,
+		Denoise:   float32(*denoiseStrength),
+		Adaptive:  AdaptiveSampling{Threshold: float32(*adaptiveThreshold), MaxSamples: *maxSamples},
+		Budget:    *budget,
+	}
 
-	// image := Image{make([]rgbRepresentation, width*height), width, height}
-	// //fonction de rendu
-	// renderFrame(image, camera, scene)
-	// //Sauvegarde de l'image
-	// image.save("./result.png")
+	camera := NewCamera(Vec3f{0, 0, -5}, Vec3f{0, 1, 0}, Vec3f{0, 0, 5})
+	camera.samples = *samples
+	camera.shutter = Shutter{0, float32(*shutter) / frames.fps}
+	switch *integrator {
+	case "direct":
+		options.Integrator = DirectLighting{}
+	case "path":
+		options.Integrator = PathTracer{*depth}
+	case "spectral":
+		options.Integrator = SpectralPathTracer{*depth}
+	case "photon":
+		options.Integrator = NewPhotonMapper(*depth, *photons, *photonNearest, float32(*photonRadius))
+	case "ppm":
+		options.Integrator = NewProgressivePhotonMapper(*depth, *photons, *photonPasses, float32(*photonRadius))
+	case "ao":
+		options.Integrator = NewAmbientOcclusion(float32(*aoRadius), *aoSamples)
+	case "normals":
+		options.Integrator = NormalShading{}
+	case "uv":
+		options.Integrator = UVShading{}
+	case "depth":
+		options.Integrator = NewDepthShading(float32(*depthRange))
+	case "facing":
+		options.Integrator = FacingRatio{}
+	case "heatmap":
+		options.Integrator = NewBVHHeatmap(float32(*heatmapScale))
+	default:
+		fmt.Printf("Unknown integrator %q\n", *integrator)
+		return
+	}
 
-	serverMain()
-	clientMain()
+	scene := Scene{}
+	if *sceneFile != "" {
+		scene, camera, err = loadScene(*sceneFile, camera)
+		if err != nil {
+			fmt.Printf("Scene error: %v\n", err)
+			return
+		}
+	} else {
+		populateSceneWithPhong(&scene)
+	}
+	if *lightSamples > 0 {
+		scene.lightSamples = *lightSamples
+	}
 
+	switch *mode {
+	case "server":
+		serverMain(scene, camera, frames, options)
+	case "client":
+		clientMain()
+	case "local":
+		localMain(scene, camera, *width, *height, options)
+	case "animate":
+		if !frames.set {
+			frames.Set("0:47")
+		}
+		animateMain(scene, camera, frames, *width, *height, options)
+	default:
+		serverMain(scene, camera, frames, options)
+		clientMain()
+	}
 }
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// ------------------------------
+// Fonction de phase de Henyey-Greenstein : g > 0 favorise la diffusion
+// vers l'avant, g < 0 vers l'arrière, g = 0 est isotrope.
+type HenyeyGreenstein struct {
+	g float32
+}
+
+// eval renvoie la densité de diffusion d'un angle de cosinus cos avec la
+// direction de propagation ; c'est aussi la densité de sample.
+func (h HenyeyGreenstein) eval(cos float32) float32 {
+	d := 1 + h.g*h.g - 2*h.g*cos
+	return (1 - h.g*h.g) / (4 * math.Pi * d * sqrt32(d))
+}
+
+// sample tire une direction diffusée autour de la direction de propagation.
+func (h HenyeyGreenstein) sample(direction Vec3f, u1, u2 float32) Vec3f {
+	var cos float32
+	if abs32(h.g) < 1e-3 {
+		cos = 1 - 2*u1
+	} else {
+		s := (1 - h.g*h.g) / (1 - h.g + 2*h.g*u1)
+		cos = clamp32((1+h.g*h.g-s*s)/(2*h.g), -1, 1)
+	}
+	sin := sqrt32(max(0, 1-cos*cos))
+	phi := 2 * math.Pi * float64(u2)
+	return fromLocal(Vec3f{sin * float32(math.Cos(phi)), sin * float32(math.Sin(phi)), cos}, direction)
+}
+
+// ------------------------------
+// Coefficients d'un milieu à densité 1, par unité de longueur. L'extinction
+// est grise ; la lumière diffusée est teintée par color.
+type mediumCoefficients struct {
+	sigmaA, sigmaS float32
+	color          Vec3f
+	phase          HenyeyGreenstein
+}
+
+func (c mediumCoefficients) sigmaT() float32 {
+	return c.sigmaA + c.sigmaS
+}
+
+// albedo : part diffusée de l'énergie éteinte
+func (c mediumCoefficients) albedo() Vec3f {
+	if c.sigmaT() <= 0 {
+		return Vec3f{}
+	}
+	return c.color.mul(c.sigmaS / c.sigmaT())
+}
+
+// Un milieu participant occupe une portion de l'espace, où ses coefficients
+// sont modulés par une densité dans [0, 1].
+type Medium interface {
+	// extent renvoie la portion [t0, t1] du rayon, de direction normalisée,
+	// contenue dans le milieu avant tmax.
+	extent(ray Ray, tmax float32) (float32, float32, bool)
+	density(p Vec3f) float32
+	coefficients() mediumCoefficients
+	// uniform indique une densité constante égale à 1 : la transmittance
+	// est alors analytique.
+	uniform() bool
+}
+
+// Au-delà de cette distance, un rayon qui ne quitte pas un milieu infini
+// est considéré comme éteint.
+const mediumMaxDistance = 1e4
+
+// ------------------------------
+// Fog : brouillard global. Sa densité décroît exponentiellement au-dessus
+// de l'altitude base quand falloff est non nul ; il est alors limité à la
+// couche où elle dépasse fogCutoff.
+type Fog struct {
+	mediumCoefficients
+	base, falloff float32
+}
+
+const fogCutoff = 1e-3
+
+func NewFog(sigmaA, sigmaS float32, color Vec3f, g float32, base, falloff float32) Fog {
+	return Fog{mediumCoefficients{sigmaA, sigmaS, color, HenyeyGreenstein{g}}, base, falloff}
+}
+
+func (f Fog) extent(ray Ray, tmax float32) (float32, float32, bool) {
+	t0, t1 := float32(0), min(tmax, mediumMaxDistance)
+	if f.falloff > 0 {
+		top := f.base - float32(math.Log(fogCutoff))/f.falloff
+		switch {
+		case ray.direction.y > 0:
+			t1 = min(t1, (top-ray.origin.y)/ray.direction.y)
+		case ray.direction.y < 0:
+			t0 = max(t0, (top-ray.origin.y)/ray.direction.y)
+		case ray.origin.y > top:
+			return 0, 0, false
+		}
+	}
+	return t0, t1, t0 < t1
+}
+
+func (f Fog) density(p Vec3f) float32 {
+	if f.falloff <= 0 {
+		return 1
+	}
+	return float32(math.Exp(float64(-f.falloff * max(p.y-f.base, 0))))
+}
+
+func (f Fog) coefficients() mediumCoefficients { return f.mediumCoefficients }
+func (f Fog) uniform() bool                    { return f.falloff <= 0 }
+
+// ------------------------------
+// HomogeneousVolume : milieu de densité constante délimité par une surface
+// fermée et convexe (sphère, boîte...), qui n'est pas rendue.
+type HomogeneousVolume struct {
+	mediumCoefficients
+	boundary GeometricObject
+}
+
+func NewHomogeneousVolume(boundary GeometricObject, sigmaA, sigmaS float32, color Vec3f, g float32) HomogeneousVolume {
+	return HomogeneousVolume{mediumCoefficients{sigmaA, sigmaS, color, HenyeyGreenstein{g}}, boundary}
+}
+
+func (v HomogeneousVolume) extent(ray Ray, tmax float32) (float32, float32, bool) {
+	inf := float32(math.Inf(1))
+	first, found := v.boundary.intersect(ray, hitEpsilon, inf)
+	if !found {
+		return 0, 0, false
+	}
+	// Une sortie en premier : le rayon part de l'intérieur
+	if !first.frontFace {
+		return 0, min(first.t, tmax), true
+	}
+	second, found := v.boundary.intersect(ray, first.t+hitEpsilon, inf)
+	if !found {
+		return 0, 0, false
+	}
+	return first.t, min(second.t, tmax), first.t < tmax
+}
+
+func (v HomogeneousVolume) density(p Vec3f) float32          { return 1 }
+func (v HomogeneousVolume) coefficients() mediumCoefficients { return v.mediumCoefficients }
+func (v HomogeneousVolume) uniform() bool                    { return true }
+
+// ------------------------------
+// DensityGrid : densités aux centres des voxels, normalisées dans [0, 1].
+type DensityGrid struct {
+	nx, ny, nz int
+	values     []float32
+}
+
+func (g DensityGrid) at(x, y, z int) float32 {
+	if x < 0 || y < 0 || z < 0 || x >= g.nx || y >= g.ny || z >= g.nz {
+		return 0
+	}
+	return g.values[(z*g.ny+y)*g.nx+x]
+}
+
+// lookup interpole trilinéairement la grille en (u, v, w) ∈ [0, 1]³.
+func (g DensityGrid) lookup(u, v, w float32) float32 {
+	if u < 0 || v < 0 || w < 0 || u > 1 || v > 1 || w > 1 {
+		return 0
+	}
+	fx, fy, fz := u*float32(g.nx)-0.5, v*float32(g.ny)-0.5, w*float32(g.nz)-0.5
+	x0, y0, z0 := int(math.Floor(float64(fx))), int(math.Floor(float64(fy))), int(math.Floor(float64(fz)))
+	dx, dy, dz := fx-float32(x0), fy-float32(y0), fz-float32(z0)
+	plane := func(z int) float32 {
+		a := lerpFloat32(g.at(x0, y0, z), g.at(x0+1, y0, z), dx)
+		b := lerpFloat32(g.at(x0, y0+1, z), g.at(x0+1, y0+1, z), dx)
+		return lerpFloat32(a, b, dy)
+	}
+	return lerpFloat32(plane(z0), plane(z0+1), dz)
+}
+
+// LoadDensityGrid lit une grille au format .vol de Mitsuba (version 3,
+// valeurs float32 ou uint8, première composante), et la boîte qu'il
+// déclare.
+func LoadDensityGrid(path string) (DensityGrid, Bounds, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DensityGrid{}, Bounds{}, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	var header struct {
+		Magic      [3]byte
+		Version    uint8
+		Encoding   int32
+		Nx, Ny, Nz int32
+		Channels   int32
+		Min, Max   [3]float32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return DensityGrid{}, Bounds{}, fmt.Errorf("density grid %s: %v", path, err)
+	}
+	if string(header.Magic[:]) != "VOL" || header.Version != 3 {
+		return DensityGrid{}, Bounds{}, fmt.Errorf("density grid %s: not a version 3 .vol file", path)
+	}
+	if header.Nx <= 0 || header.Ny <= 0 || header.Nz <= 0 || header.Channels <= 0 {
+		return DensityGrid{}, Bounds{}, fmt.Errorf("density grid %s: invalid resolution", path)
+	}
+
+	g := DensityGrid{int(header.Nx), int(header.Ny), int(header.Nz), nil}
+	count := g.nx * g.ny * g.nz
+	channels := int(header.Channels)
+	raw := make([]float32, count*channels)
+	switch header.Encoding {
+	case 1:
+		err = binary.Read(r, binary.LittleEndian, raw)
+	case 3:
+		bytes := make([]uint8, len(raw))
+		err = binary.Read(r, binary.LittleEndian, bytes)
+		for i, b := range bytes {
+			raw[i] = float32(b) / 255
+		}
+	default:
+		return DensityGrid{}, Bounds{}, fmt.Errorf("density grid %s: unsupported encoding %d", path, header.Encoding)
+	}
+	if err != nil {
+		return DensityGrid{}, Bounds{}, fmt.Errorf("density grid %s: %v", path, err)
+	}
+
+	g.values = make([]float32, count)
+	maxValue := float32(0)
+	for i := range g.values {
+		g.values[i] = max(raw[i*channels], 0)
+		maxValue = max(maxValue, g.values[i])
+	}
+	if maxValue > 0 {
+		for i := range g.values {
+			g.values[i] /= maxValue
+		}
+	}
+	bounds := Bounds{
+		Vec3f{header.Min[0], header.Min[1], header.Min[2]},
+		Vec3f{header.Max[0], header.Max[1], header.Max[2]},
+	}
+	return g, bounds, nil
+}
+
+// GridVolume : milieu hétérogène (fumée, nuage) dont la densité est lue
+// dans une grille couvrant bounds.
+type GridVolume struct {
+	mediumCoefficients
+	grid   DensityGrid
+	bounds Bounds
+}
+
+func NewGridVolume(grid DensityGrid, bounds Bounds, sigmaA, sigmaS float32, color Vec3f, g float32) GridVolume {
+	return GridVolume{mediumCoefficients{sigmaA, sigmaS, color, HenyeyGreenstein{g}}, grid, bounds}
+}
+
+func (v GridVolume) extent(ray Ray, tmax float32) (float32, float32, bool) {
+	ok, t0, t1 := clipBox(ray.origin, ray.direction, v.bounds.min, v.bounds.max)
+	t0, t1 = max(t0, 0), min(t1, tmax)
+	return t0, t1, ok && t0 < t1
+}
+
+func (v GridVolume) density(p Vec3f) float32 {
+	size := Add(v.bounds.max, v.bounds.min.inverte())
+	return v.grid.lookup(
+		(p.x-v.bounds.min.x)/size.x,
+		(p.y-v.bounds.min.y)/size.y,
+		(p.z-v.bounds.min.z)/size.z)
+}
+
+func (v GridVolume) coefficients() mediumCoefficients { return v.mediumCoefficients }
+func (v GridVolume) uniform() bool                    { return false }
+
+// ------------------------------
+// Traversée des milieux de la scène
+
+type mediumSpan struct {
+	medium Medium
+	t0, t1 float32
+}
+
+// mediumSpans renvoie les portions du rayon (normalisé) dans chaque milieu.
+func (s Scene) mediumSpans(ray Ray, tmax float32) []mediumSpan {
+	var spans []mediumSpan
+	for _, m := range s.media {
+		if m.coefficients().sigmaT() <= 0 {
+			continue
+		}
+		if t0, t1, ok := m.extent(ray, tmax); ok {
+			spans = append(spans, mediumSpan{m, t0, t1})
+		}
+	}
+	return spans
+}
+
+// transmittance le long d'un rayon d'ombre de direction normalisée, par
+// suivi de rapports (ratio tracking) dans les milieux non uniformes.
+func (s Scene) transmittance(from, direction Vec3f, distance, time float32, rng *Rng) float32 {
+	ray := Ray{from, direction, time}
+	T := float32(1)
+	for _, span := range s.mediumSpans(ray, distance) {
+		sigmaT := span.medium.coefficients().sigmaT()
+		if span.medium.uniform() {
+			T *= float32(math.Exp(float64(-sigmaT * (span.t1 - span.t0))))
+			continue
+		}
+		for t := span.t0; ; {
+			t -= float32(math.Log(float64(1-rng.float()))) / sigmaT
+			if t >= span.t1 {
+				break
+			}
+			T *= 1 - span.medium.density(ray.at(t))
+			if T < 1e-4 {
+				return 0
+			}
+		}
+	}
+	return T
+}
+
+// mediumCollision tire par suivi delta (delta tracking) le premier point de
+// diffusion du rayon avant la distance tmax. Le point renvoyé porte la
+// fonction de phase du milieu choisi comme matériau.
+func (s Scene) mediumCollision(ray Ray, tmax float32, rng *Rng) (HitRecord, bool) {
+	length := ray.direction.norme()
+	ray.direction = ray.direction.mul(1 / length)
+	spans := s.mediumSpans(ray, tmax*length)
+	if len(spans) == 0 {
+		return HitRecord{}, false
+	}
+	start, end, majorant := spans[0].t0, spans[0].t1, float32(0)
+	for _, span := range spans {
+		start, end = min(start, span.t0), max(end, span.t1)
+		majorant += span.medium.coefficients().sigmaT()
+	}
+
+	densities := make([]float32, len(spans))
+	for t := start; ; {
+		t -= float32(math.Log(float64(1-rng.float()))) / majorant
+		if t >= end {
+			return HitRecord{}, false
+		}
+		p := ray.at(t)
+		total := float32(0)
+		for i, span := range spans {
+			densities[i] = 0
+			if t >= span.t0 && t < span.t1 {
+				densities[i] = span.medium.density(p) * span.medium.coefficients().sigmaT()
+			}
+			total += densities[i]
+		}
+		u := rng.float() * majorant
+		if u >= total {
+			continue // collision fictive
+		}
+		for i, span := range spans {
+			if u < densities[i] || i == len(spans)-1 {
+				return HitRecord{t: t / length, position: p, material: mediumScattering{span.medium.coefficients()}}, true
+			}
+			u -= densities[i]
+		}
+	}
+}
+
+// Nombre de pas de la marche de rayon du rendu direct, et épaisseur
+// optique maximale d'un pas
+const (
+	volumeSteps      = 64
+	volumeStepOptics = 0.1
+)
+
+// singleScattering estime, par marche de rayon, la lumière diffusée une
+// fois vers la caméra le long du rayon jusqu'à tmax, et la transmittance
+// jusqu'à ce point. Chaque pas intègre exactement l'atténuation à
+// coefficients constants, ce qui tolère des pas longs.
+func (s Scene) singleScattering(ray Ray, tmax float32, rng *Rng) (Vec3f, float32) {
+	length := ray.direction.norme()
+	ray.direction = ray.direction.mul(1 / length)
+	spans := s.mediumSpans(ray, tmax*length)
+	if len(spans) == 0 {
+		return Vec3f{}, 1
+	}
+	start, end, majorant := spans[0].t0, spans[0].t1, float32(0)
+	for _, span := range spans {
+		start, end = min(start, span.t0), max(end, span.t1)
+		majorant += span.medium.coefficients().sigmaT()
+	}
+
+	var L Vec3f
+	T := float32(1)
+	dt := min((end-start)/volumeSteps, volumeStepOptics/majorant)
+	for t := start + rng.float()*dt; t < end && T > 1e-3; t += dt {
+		p := ray.at(t)
+		lights := s.lightsFrom(p, ray.time, rng)
+		sigmaT := float32(0)
+		var scattered Vec3f
+		for _, span := range spans {
+			if t < span.t0 || t >= span.t1 {
+				continue
+			}
+			c := span.medium.coefficients()
+			d := span.medium.density(p)
+			sigmaT += d * c.sigmaT()
+			for _, light := range lights {
+				if light.visible {
+					phase := c.phase.eval(Dot(ray.direction, light.direction))
+					scattered = Add(scattered, Mul(c.color, light.color).mul(d*c.sigmaS*phase))
+				}
+			}
+		}
+		step := min(dt, end-t)
+		// ∫ T e^(-σt s) ds sur le pas, ou T·dt si le milieu est vide ici
+		weight := T * step
+		attenuation := float32(1)
+		if sigmaT > 0 {
+			attenuation = float32(math.Exp(float64(-sigmaT * step)))
+			weight = T * (1 - attenuation) / sigmaT
+		}
+		L = Add(L, scattered.mul(weight))
+		T *= attenuation
+	}
+	return L, T
+}
+
+// ------------------------------
+// mediumScattering : matériau d'un point de diffusion dans un milieu. Comme
+// BSDF, il permet au tracé de chemins de traiter ces points comme des
+// surfaces : eval vaut albedo · phase, et le tirage suit la phase.
+type mediumScattering struct {
+	mediumCoefficients
+}
+
+func (m mediumScattering) eval(hit HitRecord, wo, wi Vec3f) Vec3f {
+	return m.albedo().mul(m.phase.eval(Dot(wo.inverte(), wi)))
+}
+
+func (m mediumScattering) sample(hit HitRecord, wo Vec3f, rng *Rng) (Vec3f, Vec3f, bool) {
+	return m.phase.sample(wo.inverte(), rng.float(), rng.float()), m.albedo(), true
+}
+
+func (m mediumScattering) pdf(hit HitRecord, wo, wi Vec3f) float32 {
+	return m.phase.eval(Dot(wo.inverte(), wi))
+}
+
+func (m mediumScattering) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	return directLighting(m, ray, hit, scene.sampleLights(ray, hit, positionRng(hit.position)))
+}
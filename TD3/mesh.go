@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// ------------------------------
+// Maillage de triangles. Il s'utilise par pointeur pour que toutes les
+// instances (Transformed) partagent les mêmes sommets.
+type Mesh struct {
+	vertices  []Vec3f
+	triangles [][3]int
+	Material  Materials
+}
+
+// intersectTriangle : algorithme de Möller-Trumbore. Renvoie t et les
+// coordonnées barycentriques (u, v) du point d'impact.
+func intersectTriangle(ray Ray, v0, v1, v2 Vec3f) (bool, float32, Vec2f) {
+	const epsilon = 1e-9
+	e1 := Add(v1, v0.inverte())
+	e2 := Add(v2, v0.inverte())
+
+	p := cross(ray.direction, e2)
+	det := Dot(e1, p)
+	if det > -epsilon && det < epsilon {
+		return false, 0, Vec2f{}
+	}
+	invDet := 1 / det
+
+	s := Add(ray.origin, v0.inverte())
+	u := Dot(s, p) * invDet
+	if u < 0 || u > 1 {
+		return false, 0, Vec2f{}
+	}
+	q := cross(s, e1)
+	v := Dot(ray.direction, q) * invDet
+	if v < 0 || u+v > 1 {
+		return false, 0, Vec2f{}
+	}
+	return true, Dot(e2, q) * invDet, Vec2f{u, v}
+}
+
+func (m *Mesh) bounds() Bounds {
+	b := emptyBounds()
+	for _, v := range m.vertices {
+		b = b.addPoint(v)
+	}
+	return b
+}
+
+// Les uv du point d'impact sont ses coordonnées barycentriques dans le triangle.
+func (m *Mesh) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	closest := -1
+	var uv Vec2f
+	for i, tri := range m.triangles {
+		if ok, t, bary := intersectTriangle(ray, m.vertices[tri[0]], m.vertices[tri[1]], m.vertices[tri[2]]); ok && t > tmin && t < tmax {
+			closest, tmax, uv = i, t, bary
+		}
+	}
+	if closest < 0 {
+		return HitRecord{}, false
+	}
+
+	tri := m.triangles[closest]
+	v0, v1, v2 := m.vertices[tri[0]], m.vertices[tri[1]], m.vertices[tri[2]]
+	e1 := Add(v1, v0.inverte())
+	n := cross(e1, Add(v2, v0.inverte())).normalized()
+	hit := newHit(ray, tmax, n, uv, m.Material)
+	hit.tangent = e1
+	return hit, true
+}
+
+func (m *Mesh) material() Materials {
+	return m.Material
+}
+
+// Copie légère : les sommets restent partagés avec les autres instances
+func (m *Mesh) withMaterial(material Materials) GeometricObject {
+	inherited := *m
+	inherited.Material = material
+	return &inherited
+}
+
+// ------------------------------
+// Sapin stylisé : un tronc (prisme) surmonté d'une pyramide, base en y = 0.
+func NewTreeMesh(material Materials) *Mesh {
+	mesh := &Mesh{Material: material}
+	addPyramid := func(base, top float32, halfWidth float32) {
+		first := len(mesh.vertices)
+		mesh.vertices = append(mesh.vertices,
+			Vec3f{-halfWidth, base, -halfWidth},
+			Vec3f{halfWidth, base, -halfWidth},
+			Vec3f{halfWidth, base, halfWidth},
+			Vec3f{-halfWidth, base, halfWidth},
+			Vec3f{0, top, 0},
+		)
+		for i := 0; i < 4; i++ {
+			mesh.triangles = append(mesh.triangles, [3]int{first + i, first + (i+1)%4, first + 4})
+		}
+		mesh.triangles = append(mesh.triangles, [3]int{first, first + 2, first + 1}, [3]int{first, first + 3, first + 2})
+	}
+	addPyramid(0, 0.6, 0.1)
+	addPyramid(0.4, 2, 0.6)
+	return mesh
+}
+
+// populateForest ajoute au graphe de scène un groupe "foret" de count
+// instances d'un même sapin (sapin0, sapin1...), posées sur un disque de
+// rayon spread autour de center.
+func populateForest(scene *Scene, count int, center Vec3f, spread float32) {
+	tree := NewTreeMesh(NewPhongMaterial(Vec3f{0.1, 0.5, 0.15}, 0.1, 8))
+	forest := scene.addNode(NewNode("foret"))
+
+	for i := 0; i < count; i++ {
+		angle := rand.Float32() * 2 * math.Pi
+		distance := spread * float32(math.Sqrt(float64(rand.Float32())))
+		position := Add(center, Vec3f{
+			x: distance * float32(math.Cos(float64(angle))),
+			z: distance * float32(math.Sin(float64(angle))),
+		})
+		size := 0.5 + rand.Float32()
+		toWorld := trs(position, Vec3f{0, rand.Float32() * 2 * math.Pi, 0}, Vec3f{size, size, size})
+		if instance, ok := NewInstance(tree, toWorld); ok {
+			forest.add(NewObjectNode(fmt.Sprintf("sapin%d", i), instance))
+		}
+	}
+}
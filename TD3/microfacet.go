@@ -0,0 +1,138 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Matériau à microfacettes de Cook-Torrance : distribution GGX, terme
+// géométrique de Smith et Fresnel de Schlick, paramétré en metallic /
+// roughness comme dans la plupart des outils de look dev. La part diffuse
+// reçoit l'énergie que le Fresnel ne réfléchit pas.
+type CookTorrance struct {
+	baseColor           Vec3f
+	metallic, roughness float32
+
+	baseColorMap, metallicMap, roughnessMap Texture
+}
+
+func NewCookTorrance(baseColor Vec3f, metallic, roughness float32) CookTorrance {
+	return CookTorrance{baseColor: baseColor, metallic: metallic, roughness: roughness}
+}
+
+// Réflectance à incidence normale des diélectriques courants
+const dielectricF0 = 0.04
+
+// Paramètres au point d'impact, textures comprises
+type microfacetParams struct {
+	baseColor, f0 Vec3f
+	metallic      float32
+	alpha         float32 // roughness², borné pour éviter un pic de Dirac
+}
+
+func (m CookTorrance) params(hit HitRecord) microfacetParams {
+	base := modulate(m.baseColor, m.baseColorMap, hit)
+	metallic := clamp32(modulate(Vec3f{m.metallic, 0, 0}, m.metallicMap, hit).x, 0, 1)
+	roughness := clamp32(modulate(Vec3f{m.roughness, 0, 0}, m.roughnessMap, hit).x, 0, 1)
+	return microfacetParams{
+		baseColor: base,
+		f0:        lerpVec3f(Vec3f{dielectricF0, dielectricF0, dielectricF0}, base, metallic),
+		metallic:  metallic,
+		alpha:     max(roughness*roughness, 1e-3),
+	}
+}
+
+// ggxD : densité des normales de microfacettes
+func ggxD(cosH, alpha float32) float32 {
+	a2 := alpha * alpha
+	d := cosH*cosH*(a2-1) + 1
+	return a2 / (math.Pi * d * d)
+}
+
+// smithG1 : masquage de Smith pour GGX, dans une direction de cosinus cos
+func smithG1(cos, alpha float32) float32 {
+	a2 := alpha * alpha
+	return 2 * cos / (cos + sqrt32(a2+(1-a2)*cos*cos))
+}
+
+func schlick(f0 Vec3f, cos float32) Vec3f {
+	k := float32(math.Pow(float64(1-clamp32(cos, 0, 1)), 5))
+	return Add(f0, Add(Vec3f{1, 1, 1}, f0.inverte()).mul(k))
+}
+
+func (m CookTorrance) evalParams(p microfacetParams, n, wo, wi Vec3f) Vec3f {
+	cosO, cosI := Dot(n, wo), Dot(n, wi)
+	if cosO <= 0 || cosI <= 0 {
+		return Vec3f{}
+	}
+	h := Add(wo, wi).normalized()
+	F := schlick(p.f0, Dot(wi, h))
+	D := ggxD(Dot(n, h), p.alpha)
+	G := smithG1(cosO, p.alpha) * smithG1(cosI, p.alpha)
+
+	specular := F.mul(D * G / (4 * cosO * cosI))
+	kd := Mul(Add(Vec3f{1, 1, 1}, F.inverte()), p.baseColor).mul((1 - p.metallic) / math.Pi)
+	return Add(specular, kd).mul(cosI)
+}
+
+func (m CookTorrance) eval(hit HitRecord, wo, wi Vec3f) Vec3f {
+	return m.evalParams(m.params(hit), hit.shadingNormal, wo, wi)
+}
+
+// specularProbability : part des échantillons tirés selon GGX plutôt que
+// selon le cosinus, d'après le poids estimé du lobe spéculaire.
+func (p microfacetParams) specularProbability(n, wo Vec3f) float32 {
+	spec := luminance(schlick(p.f0, Dot(n, wo)))
+	diffuse := luminance(p.baseColor) * (1 - p.metallic)
+	if spec+diffuse == 0 {
+		return 0.5
+	}
+	return clamp32(spec/(spec+diffuse), 0.1, 0.9)
+}
+
+func (m CookTorrance) pdfParams(p microfacetParams, n, wo, wi Vec3f) float32 {
+	cosI := Dot(n, wi)
+	if cosI <= 0 || Dot(n, wo) <= 0 {
+		return 0
+	}
+	h := Add(wo, wi).normalized()
+	cosH := Dot(n, h)
+	specPdf := ggxD(cosH, p.alpha) * cosH / (4 * Dot(wo, h))
+	ps := p.specularProbability(n, wo)
+	return ps*specPdf + (1-ps)*cosI/math.Pi
+}
+
+func (m CookTorrance) pdf(hit HitRecord, wo, wi Vec3f) float32 {
+	return m.pdfParams(m.params(hit), hit.shadingNormal, wo, wi)
+}
+
+// sample tire soit une demi-normale selon D(h) cos θh puis réfléchit wo,
+// soit une direction diffuse, et pondère par la densité du mélange.
+func (m CookTorrance) sample(hit HitRecord, wo Vec3f, rng *Rng) (Vec3f, Vec3f, bool) {
+	p := m.params(hit)
+	n := hit.shadingNormal
+	if Dot(n, wo) <= 0 {
+		return Vec3f{}, Vec3f{}, false
+	}
+
+	var wi Vec3f
+	if rng.float() < p.specularProbability(n, wo) {
+		u1, u2 := rng.float(), rng.float()
+		cos2 := (1 - u1) / (1 + (p.alpha*p.alpha-1)*u1)
+		cosH, sinH := sqrt32(cos2), sqrt32(max(0, 1-cos2))
+		phi := 2 * math.Pi * float64(u2)
+		h := fromLocal(Vec3f{sinH * float32(math.Cos(phi)), sinH * float32(math.Sin(phi)), cosH}, n)
+		wi = reflect(wo.inverte(), h)
+	} else {
+		wi = cosineHemisphere(n, rng.float(), rng.float())
+	}
+
+	pdf := m.pdfParams(p, n, wo, wi)
+	if pdf <= 0 {
+		return Vec3f{}, Vec3f{}, false
+	}
+	return wi, m.evalParams(p, n, wo, wi).mul(1 / pdf), true
+}
+
+// render : éclairage direct, comme Phong.
+func (m CookTorrance) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	return directLighting(m, ray, hit, scene.sampleLights(ray, hit, positionRng(hit.position)))
+}
@@ -0,0 +1,74 @@
+package main
+
+// ------------------------------
+// Déplacement d'un objet en fonction du temps, relatif à sa position au
+// début de l'image.
+type Motion interface {
+	offset(time float32) Vec3f
+	// sweep englobe la boîte b sur toute la durée d'une image (une seconde au plus)
+	sweep(b Bounds) Bounds
+}
+
+// Translation à vitesse constante (unités par seconde)
+type LinearMotion struct {
+	velocity Vec3f
+}
+
+func (m LinearMotion) offset(time float32) Vec3f {
+	return m.velocity.mul(time)
+}
+
+func (m LinearMotion) sweep(b Bounds) Bounds {
+	return b.union(b.translated(m.offset(1)))
+}
+
+// KeyframedMotion suit une piste d'animation en temps absolu : start est
+// l'instant du début de l'image, où le déplacement est nul.
+type KeyframedMotion struct {
+	path  Track[Vec3f]
+	start float32
+}
+
+func (m KeyframedMotion) origin() Vec3f {
+	return m.path.sample(m.start, Vec3f{}, lerpVec3f)
+}
+
+func (m KeyframedMotion) offset(time float32) Vec3f {
+	return Add(m.path.sample(m.start+time, Vec3f{}, lerpVec3f), m.origin().inverte())
+}
+
+// Les points de contrôle de Bézier englobent la courbe : la boîte est sûre.
+func (m KeyframedMotion) sweep(b Bounds) Bounds {
+	origin := m.origin().inverte()
+	res := b
+	for _, k := range m.path.keys {
+		res = res.union(b.translated(Add(k.value, origin))).
+			union(b.translated(Add(k.in, origin))).
+			union(b.translated(Add(k.out, origin)))
+	}
+	return res
+}
+
+// Moving anime n'importe quel GeometricObject : le rayon est déplacé en
+// sens inverse du mouvement à l'instant qu'il porte.
+type Moving struct {
+	object GeometricObject
+	motion Motion
+}
+
+func (m Moving) localRay(ray Ray) Ray {
+	ray.origin = Add(ray.origin, m.motion.offset(ray.time).inverte())
+	return ray
+}
+
+func (m Moving) bounds() Bounds {
+	return m.motion.sweep(m.object.bounds())
+}
+
+func (m Moving) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	hit, ok := m.object.intersect(m.localRay(ray), tmin, tmax)
+	if ok {
+		hit.position = ray.at(hit.t)
+	}
+	return hit, ok
+}
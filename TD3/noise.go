@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ------------------------------
+// Table de permutation partagée par les bruits de Perlin, simplex et Worley.
+type Noise struct {
+	perm [512]int
+}
+
+func NewNoise(seed int64) *Noise {
+	p := &Noise{}
+	r := rand.New(rand.NewSource(seed))
+	for i, v := range r.Perm(256) {
+		p.perm[i], p.perm[i+256] = v, v
+	}
+	return p
+}
+
+func fade(t float32) float32 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+// grad : produit scalaire avec l'un des 12 gradients du cube
+func grad(hash int, x, y, z float32) float32 {
+	h := hash & 15
+	u, v := y, z
+	if h < 8 {
+		u = x
+	}
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// perlin : bruit de gradient de Perlin ("improved noise", 2002), dans [-1, 1].
+func (p *Noise) perlin(x, y, z float32) float32 {
+	fx, fy, fz := math.Floor(float64(x)), math.Floor(float64(y)), math.Floor(float64(z))
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z = x-float32(fx), y-float32(fy), z-float32(fz)
+	u, v, w := fade(x), fade(y), fade(z)
+
+	perm := &p.perm
+	a := perm[X] + Y
+	aa, ab := perm[a]+Z, perm[a+1]+Z
+	b := perm[X+1] + Y
+	ba, bb := perm[b]+Z, perm[b+1]+Z
+
+	return lerpFloat32(
+		lerpFloat32(
+			lerpFloat32(grad(perm[aa], x, y, z), grad(perm[ba], x-1, y, z), u),
+			lerpFloat32(grad(perm[ab], x, y-1, z), grad(perm[bb], x-1, y-1, z), u), v),
+		lerpFloat32(
+			lerpFloat32(grad(perm[aa+1], x, y, z-1), grad(perm[ba+1], x-1, y, z-1), u),
+			lerpFloat32(grad(perm[ab+1], x, y-1, z-1), grad(perm[bb+1], x-1, y-1, z-1), u), v),
+		w)
+}
+
+// fbm somme octaves couches de bruit, chacune deux fois plus fine et deux
+// fois moins forte que la précédente. Le résultat reste dans [-1, 1].
+func fbm(noise func(x, y, z float32) float32, p Vec3f, octaves int) float32 {
+	sum, amplitude, total := float32(0), float32(1), float32(0)
+	for i := 0; i < octaves; i++ {
+		sum += amplitude * noise(p.x, p.y, p.z)
+		total += amplitude
+		amplitude *= 0.5
+		p = p.mul(2)
+	}
+	return sum / total
+}
+
+// simplex : bruit simplexe de Perlin (version de Gustavson), dans [-1, 1].
+// Moins d'artefacts alignés sur les axes que perlin.
+func (p *Noise) simplex(x, y, z float32) float32 {
+	const f3, g3 = 1.0 / 3, 1.0 / 6
+	s := (x + y + z) * f3
+	i, j, k := math.Floor(float64(x+s)), math.Floor(float64(y+s)), math.Floor(float64(z+s))
+	t := float32(i+j+k) * g3
+	x0, y0, z0 := x-(float32(i)-t), y-(float32(j)-t), z-(float32(k)-t)
+
+	// Sommets intermédiaires du simplexe qui contient le point
+	var i1, j1, k1, i2, j2, k2 int
+	switch {
+	case x0 >= y0 && y0 >= z0:
+		i1, i2, j2 = 1, 1, 1
+	case x0 >= z0 && z0 >= y0:
+		i1, i2, k2 = 1, 1, 1
+	case z0 >= x0 && x0 >= y0:
+		k1, i2, k2 = 1, 1, 1
+	case z0 >= y0 && y0 >= x0:
+		k1, j2, k2 = 1, 1, 1
+	case y0 >= z0 && z0 >= x0:
+		j1, j2, k2 = 1, 1, 1
+	default:
+		j1, i2, j2 = 1, 1, 1
+	}
+
+	ii, jj, kk := int(i)&255, int(j)&255, int(k)&255
+	perm := &p.perm
+	corner := func(di, dj, dk int, x, y, z float32) float32 {
+		t := 0.6 - x*x - y*y - z*z
+		if t < 0 {
+			return 0
+		}
+		t *= t
+		return t * t * grad(perm[ii+di+perm[jj+dj+perm[kk+dk]]], x, y, z)
+	}
+	return 32 * (corner(0, 0, 0, x0, y0, z0) +
+		corner(i1, j1, k1, x0-float32(i1)+g3, y0-float32(j1)+g3, z0-float32(k1)+g3) +
+		corner(i2, j2, k2, x0-float32(i2)+2*g3, y0-float32(j2)+2*g3, z0-float32(k2)+2*g3) +
+		corner(1, 1, 1, x0-1+3*g3, y0-1+3*g3, z0-1+3*g3))
+}
+
+// worley : bruit cellulaire de Worley, distance au point caractéristique le
+// plus proche (un par cellule de la grille unité), dans [0, ~1].
+func (p *Noise) worley(x, y, z float32) float32 {
+	fx, fy, fz := math.Floor(float64(x)), math.Floor(float64(y)), math.Floor(float64(z))
+	best := float32(math.MaxFloat32)
+	for dz := -1; dz <= 1; dz++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				X, Y, Z := int(fx)+dx, int(fy)+dy, int(fz)+dz
+				h := p.perm[X&255+p.perm[Y&255+p.perm[Z&255]]]
+				feature := Vec3f{
+					float32(X) + float32(p.perm[h])/256,
+					float32(Y) + float32(p.perm[h+1])/256,
+					float32(Z) + float32(p.perm[h+2])/256,
+				}
+				best = min(best, Add(feature, Vec3f{-x, -y, -z}).norme())
+			}
+		}
+	}
+	return best
+}
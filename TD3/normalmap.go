@@ -0,0 +1,68 @@
+package main
+
+// ------------------------------
+// NormalMapped perturbe la normale d'éclairage avant de déléguer le rendu au
+// matériau qu'il enveloppe, ce qui permet d'ajouter une carte à n'importe
+// quel matériau.
+//
+// normalMap est exprimée dans le repère tangent (t, b, n), composantes
+// ramenées de [0, 1] à [-1, 1], le vert suivant les v croissants (v = 0 en
+// haut de l'image). bumpMap est une carte de hauteur lue dans sa première
+// composante, dont les pentes sont amplifiées par bumpScale.
+type NormalMapped struct {
+	material  Materials
+	normalMap Texture
+	bumpMap   Texture
+	bumpScale float32
+}
+
+// Pas des différences finies de la carte de hauteur, en unités UV
+const bumpDelta = 1.0 / 1024
+
+// tangentFrame orthonormalise la tangente de l'impact contre la normale
+// sortante.
+func tangentFrame(hit HitRecord) (Vec3f, Vec3f, Vec3f) {
+	n := hit.geometricNormal
+	t := Add(hit.tangent, n.mul(-Dot(n, hit.tangent)))
+	if t.norme() < 1e-6 {
+		t, _ = orthonormalBasis(n)
+	} else {
+		t = t.normalized()
+	}
+	return t, cross(n, t), n
+}
+
+func (m NormalMapped) perturb(hit HitRecord) Vec3f {
+	t, b, n := tangentFrame(hit)
+
+	if m.normalMap != nil {
+		c := m.normalMap.value(hit.uv, hit.position).mul(2)
+		c = Add(c, Vec3f{-1, -1, -1})
+		n = Add(Add(t.mul(c.x), b.mul(c.y)), n.mul(c.z)).normalized()
+		t = Add(t, n.mul(-Dot(n, t))).normalized()
+		b = cross(n, t)
+	}
+
+	if m.bumpMap != nil {
+		// Les textures solides suivent le point déplacé le long de la surface
+		height := func(du, dv float32) float32 {
+			uv := Vec2f{hit.uv.x + du, hit.uv.y + dv}
+			p := Add(hit.position, Add(t.mul(du), b.mul(dv)))
+			return m.bumpMap.value(uv, p).x
+		}
+		h := height(0, 0)
+		dhdu := (height(bumpDelta, 0) - h) / bumpDelta
+		dhdv := (height(0, bumpDelta) - h) / bumpDelta
+		n = Add(n, Add(t.mul(dhdu), b.mul(dhdv)).mul(-m.bumpScale)).normalized()
+	}
+
+	if !hit.frontFace {
+		n = n.inverte()
+	}
+	return n
+}
+
+func (m NormalMapped) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	hit.shadingNormal = m.perturb(hit)
+	return m.material.render(ray, hit, scene)
+}
@@ -0,0 +1,228 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Un intégrateur calcule la radiance reçue le long d'un rayon de caméra.
+type Integrator interface {
+	radiance(scene Scene, ray Ray, rng *Rng) Vec3f
+}
+
+// DirectLighting : rendu direct historique, chaque matériau s'éclaire seul.
+type DirectLighting struct{}
+
+func (DirectLighting) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	return renderPixel(scene, ray, rng)
+}
+
+// ------------------------------
+// Matériaux échantillonnables par le tracé de chemins. Les directions wo
+// (vers l'observateur) et wi (vers la lumière) sont normalisées et partent
+// du point d'impact.
+type BSDF interface {
+	// eval renvoie f(wo, wi) · cos θi.
+	eval(hit HitRecord, wo, wi Vec3f) Vec3f
+	// sample tire wi et renvoie le poids f · cos θi / pdf.
+	sample(hit HitRecord, wo Vec3f, rng *Rng) (wi Vec3f, weight Vec3f, ok bool)
+	pdf(hit HitRecord, wo, wi Vec3f) float32
+}
+
+func (l Lambert) eval(hit HitRecord, wo, wi Vec3f) Vec3f {
+	cos := Dot(hit.shadingNormal, wi)
+	if cos <= 0 {
+		return Vec3f{}
+	}
+	return modulate(l.kd, l.kdMap, hit).mul(cos / math.Pi)
+}
+
+func (l Lambert) sample(hit HitRecord, wo Vec3f, rng *Rng) (Vec3f, Vec3f, bool) {
+	wi := cosineHemisphere(hit.shadingNormal, rng.float(), rng.float())
+	return wi, modulate(l.kd, l.kdMap, hit), true
+}
+
+func (l Lambert) pdf(hit HitRecord, wo, wi Vec3f) float32 {
+	return max(0, Dot(hit.shadingNormal, wi)) / math.Pi
+}
+
+// asBSDF applique les cartes de normales avant de renvoyer le BSDF du
+// matériau de l'impact, s'il en a un.
+func asBSDF(hit HitRecord) (BSDF, HitRecord, bool) {
+	if m, ok := hit.material.(NormalMapped); ok {
+		hit.shadingNormal = m.perturb(hit)
+		hit.material = m.material
+	}
+	bsdf, ok := hit.material.(BSDF)
+	return bsdf, hit, ok
+}
+
+// directLighting somme la contribution des échantillons de lumière visibles.
+func directLighting(b BSDF, ray Ray, hit HitRecord, lights []lightSample) Vec3f {
+	wo := ray.direction.inverte().normalized()
+	var res Vec3f
+	for _, light := range lights {
+		if light.visible {
+			res = Add(res, Mul(b.eval(hit, wo, light.direction), light.color))
+		}
+	}
+	return res
+}
+
+// environmentLighting tire une direction selon la densité de
+// l'environnement, pondérée contre le tirage du BSDF.
+func environmentLighting(b BSDF, ray Ray, hit HitRecord, scene Scene, rng *Rng) Vec3f {
+	if scene.environment == nil {
+		return Vec3f{}
+	}
+	wi, lightPdf := scene.environment.sample(rng.float(), rng.float())
+	inf := float32(math.Inf(1))
+	if lightPdf <= 0 || scene.occluded(hit.position, wi, inf, ray.time) {
+		return Vec3f{}
+	}
+	wo := ray.direction.inverte().normalized()
+	f := b.eval(hit, wo, wi)
+	if f == (Vec3f{}) {
+		return Vec3f{}
+	}
+	weight := powerHeuristic(lightPdf, b.pdf(hit, wo, wi))
+	if len(scene.media) > 0 {
+		weight *= scene.transmittance(hit.position, wi, inf, ray.time, rng)
+	}
+	return Mul(f, scene.environment.radiance(wi)).mul(weight / lightPdf)
+}
+
+// powerHeuristic : poids MIS d'une stratégie de densité a face à une
+// stratégie de densité b, un échantillon chacune.
+func powerHeuristic(a, b float32) float32 {
+	if a <= 0 {
+		return 0
+	}
+	return a * a / (a*a + b*b)
+}
+
+// ------------------------------
+// PathTracer : tracé de chemins avec estimation de l'éclairage direct à
+// chaque rebond et roulette russe au-delà de rouletteDepth. Toutes les
+// sources passent par Scene.sampleLights, comme pour le rendu direct.
+// Un matériau qui n'implémente pas BSDF termine le chemin par son rendu
+// direct. L'émission n'est comptée qu'en vue directe : au-delà, elle est
+// déjà estimée par l'échantillonnage des sources surfaciques.
+//
+// L'environnement est échantillonné à chaque rebond selon sa densité, et
+// retrouvé par les rayons perdus ; les deux estimations sont pondérées par
+// l'heuristique de puissance (MIS), ce qui garde nets les reflets d'une
+// carte d'environnement sur les matériaux peu rugueux.
+//
+// Dans les milieux participants, le suivi delta place des points de
+// diffusion le long du rayon ; ils sont traités comme des impacts dont le
+// matériau suit la fonction de phase.
+//
+// Après un rebond spéculaire (verre), que l'échantillonnage des sources ne
+// peut pas suivre, l'émission et l'environnement touchés comptent en
+// entier. Avec une carte de caustiques, les surfaces émissives vues à
+// travers le verre depuis une surface diffuse sont laissées aux photons,
+// consultés au premier impact diffus.
+type PathTracer struct {
+	maxDepth int
+}
+
+const rouletteDepth = 3
+
+// causticEstimator : estimation par photons des caustiques (chemins partis
+// d'une source et réfléchis ou réfractés de façon spéculaire) reçues par une
+// surface diffuse.
+type causticEstimator interface {
+	caustics(b BSDF, hit HitRecord, wo Vec3f) Vec3f
+}
+
+func (p PathTracer) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	return p.trace(scene, ray, rng, nil)
+}
+
+// trace suit le chemin d'un rayon de caméra ; caustics peut être nil.
+func (p PathTracer) trace(scene Scene, ray Ray, rng *Rng, caustics causticEstimator) Vec3f {
+	var L Vec3f
+	throughput := Vec3f{1, 1, 1}
+	bsdfPdf := float32(0) // densité du dernier rebond, nulle en vue directe
+	delta := false        // dernier rebond spéculaire
+	specularChain := true // tous les rebonds depuis la caméra sont spéculaires
+	for depth := 0; depth < p.maxDepth; depth++ {
+		hit, found := scene.closest(ray)
+		if len(scene.media) > 0 {
+			tmax := float32(math.Inf(1))
+			if found {
+				tmax = hit.t
+			}
+			if collision, ok := scene.mediumCollision(ray, tmax, rng); ok {
+				hit, found = collision, true
+			}
+		}
+		if !found {
+			if scene.environment != nil {
+				weight := float32(1)
+				if depth > 0 && !delta {
+					weight = powerHeuristic(bsdfPdf, scene.environment.pdf(ray.direction))
+				}
+				L = Add(L, Mul(throughput, scene.background(ray).mul(weight)))
+			}
+			break
+		}
+		if hit.material == nil {
+			break
+		}
+		if e, ok := hit.material.(Emissive); ok {
+			// Une source surfacique est déjà comptée par l'éclairage direct
+			// ou les photons ; les autres surfaces émissives ne sont vues
+			// que par les chemins
+			counted := depth == 0 || (delta && (caustics == nil || specularChain)) || !scene.sampledEmitter(hit)
+			if counted && e.emits(hit) {
+				L = Add(L, Mul(throughput, e.radiance()))
+			}
+			break
+		}
+		if sss, ok := hit.material.(Subsurface); ok {
+			// La marche sous la surface remplace l'impact par le point de
+			// sortie, éclairé comme une surface diffuse
+			exit, weight, ok := sss.walk(scene, hit, ray.time, rng)
+			if !ok {
+				break
+			}
+			throughput = Mul(throughput, weight)
+			hit = exit
+			specularChain = false
+		}
+		bsdf, hit, ok := asBSDF(hit)
+		if !ok {
+			L = Add(L, Mul(throughput, hit.material.render(ray, hit, scene)))
+			break
+		}
+
+		wo := ray.direction.inverte().normalized()
+		delta = isDelta(bsdf)
+		if !delta {
+			L = Add(L, Mul(throughput, directLighting(bsdf, ray, hit, scene.sampleLights(ray, hit, rng))))
+			L = Add(L, Mul(throughput, environmentLighting(bsdf, ray, hit, scene, rng)))
+			// Caustiques au premier impact diffus seulement : vues après un
+			// rebond diffus, elles ne font que du bruit
+			if _, inMedium := hit.material.(mediumScattering); caustics != nil && specularChain && !inMedium {
+				L = Add(L, Mul(throughput, caustics.caustics(bsdf, hit, wo)))
+			}
+		}
+		specularChain = specularChain && delta
+
+		wi, weight, ok := bsdf.sample(hit, wo, rng)
+		if !ok {
+			break
+		}
+		bsdfPdf = bsdf.pdf(hit, wo, wi)
+		throughput = Mul(throughput, weight)
+		if depth >= rouletteDepth {
+			survive := min(0.95, max(throughput.x, throughput.y, throughput.z))
+			if rng.float() >= survive {
+				break
+			}
+			throughput = throughput.mul(1 / survive)
+		}
+		ray = Ray{hit.position, wi, ray.time}
+	}
+	return L
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// ------------------------------
+// Cartes de photons pour les caustiques (Jensen 1996). Des photons partent
+// des sources, traversent ou rebondissent sur les surfaces spéculaires, et
+// sont stockés au premier impact diffus qui suit au moins un rebond
+// spéculaire. Le tracé de chemins s'occupe du reste de l'éclairage. Les
+// sources historiques (Light), sans puissance physique, et l'environnement
+// n'émettent pas de photons ; les milieux participants sont ignorés.
+
+// photon : flux power arrivé en position depuis direction (vers la source).
+type photon struct {
+	position  Vec3f
+	direction Vec3f
+	power     Vec3f
+	axis      int
+}
+
+const photonMaxDepth = 8
+
+// photonSource : émission d'une source. emit tire un rayon et un facteur
+// d'espérance 1 qui module power / nombre de photons.
+type photonSource struct {
+	power Vec3f
+	emit  func(rng *Rng) (Ray, float32, bool)
+}
+
+// photonSources renvoie les sources d'une scène préparée capables d'émettre.
+func photonSources(scene Scene) []photonSource {
+	var sources []photonSource
+	for _, light := range scene.lights {
+		switch l := light.(type) {
+		case PointLight:
+			sources = append(sources, photonSource{l.color.mul(4 * math.Pi * l.intensity), func(rng *Rng) (Ray, float32, bool) {
+				return Ray{l.position, uniformSphere(rng.float(), rng.float()), 0}, 1, true
+			}})
+		case SpotLight:
+			cosOuter := float32(math.Cos(float64(l.outer)))
+			solidAngle := 2 * math.Pi * (1 - cosOuter)
+			sources = append(sources, photonSource{l.color.mul(solidAngle * l.intensity), func(rng *Rng) (Ray, float32, bool) {
+				dir := uniformCone(l.direction, cosOuter, rng.float(), rng.float())
+				cone := l.cone(Dot(dir, l.direction))
+				return Ray{l.position, dir, 0}, cone, cone > 0
+			}})
+		case DirectionalLight:
+			// Un disque perpendiculaire couvrant les objets bornés de la scène
+			if scene.accel == nil || len(scene.accel.nodes) == 0 {
+				continue
+			}
+			bounds := scene.accel.nodes[0].bounds
+			center := bounds.centroid()
+			radius := Add(bounds.max, bounds.min.inverte()).norme() / 2
+			dir := l.direction
+			origin := Add(center, dir.mul(-2*radius))
+			sources = append(sources, photonSource{l.color.mul(l.illuminance * math.Pi * radius * radius), func(rng *Rng) (Ray, float32, bool) {
+				r := radius * sqrt32(rng.float())
+				phi := 2 * math.Pi * float64(rng.float())
+				offset := fromLocal(Vec3f{r * float32(math.Cos(phi)), r * float32(math.Sin(phi)), 0}, dir)
+				return Ray{Add(origin, offset), dir, 0}, 1, true
+			}})
+		}
+	}
+	for _, light := range scene.areaLights {
+		a := light
+		// Aire estimée d'après la densité d'un point : les émetteurs sont
+		// échantillonnés uniformément
+		_, _, pdf := a.emitter.sampleSurface(0.5, 0.5)
+		if pdf <= 0 {
+			continue
+		}
+		sources = append(sources, photonSource{a.emission.mul(math.Pi / pdf), func(rng *Rng) (Ray, float32, bool) {
+			q, normal, qPdf := a.emitter.sampleSurface(rng.float(), rng.float())
+			if qPdf <= 0 {
+				return Ray{}, 0, false
+			}
+			dir := cosineHemisphere(normal, rng.float(), rng.float())
+			return Ray{q, dir, 0}, pdf / qPdf, true
+		}})
+	}
+	return sources
+}
+
+// tracePhotons émet count photons depuis les sources, graine seed, et
+// renvoie les photons de caustiques.
+func tracePhotons(scene Scene, count int, seed uint64) []photon {
+	sources := photonSources(scene)
+	if len(sources) == 0 || count <= 0 {
+		return nil
+	}
+	weights := make([]float32, len(sources))
+	for i, s := range sources {
+		weights[i] = luminance(s.power)
+	}
+	choice := newDistribution1D(weights)
+
+	var photons []photon
+	rng := NewRng(seed)
+	for i := 0; i < count; i++ {
+		index, _, p := choice.sample(rng.float())
+		ray, scale, ok := sources[index].emit(rng)
+		if !ok || p <= 0 {
+			continue
+		}
+		power := sources[index].power.mul(scale / (p * float32(count)))
+		specular := false
+		for depth := 0; depth < photonMaxDepth; depth++ {
+			hit, found := scene.closest(ray)
+			if !found || hit.material == nil {
+				break
+			}
+			bsdf, hit, ok := asBSDF(hit)
+			if !ok {
+				break
+			}
+			wo := ray.direction.inverte().normalized()
+			if !isDelta(bsdf) {
+				if specular {
+					photons = append(photons, photon{position: hit.position, direction: wo, power: power})
+				}
+				break
+			}
+			wi, weight, ok := bsdf.sample(hit, wo, rng)
+			if !ok {
+				break
+			}
+			power = Mul(power, weight)
+			specular = true
+			ray = Ray{hit.position, wi, 0}
+		}
+	}
+	return photons
+}
+
+// ------------------------------
+// photonMap : arbre kd équilibré rangé dans le tableau lui-même ; le nœud
+// d'un intervalle est son milieu, qui coupe selon axis.
+type photonMap struct {
+	photons []photon
+}
+
+func newPhotonMap(photons []photon) *photonMap {
+	m := &photonMap{photons}
+	m.build(0, len(photons))
+	return m
+}
+
+func (m *photonMap) build(lo, hi int) {
+	if hi-lo <= 1 {
+		return
+	}
+	bounds := emptyBounds()
+	for _, p := range m.photons[lo:hi] {
+		bounds = bounds.addPoint(p.position)
+	}
+	extent := Add(bounds.max, bounds.min.inverte())
+	split := 0
+	if extent.y > extent.x && extent.y >= extent.z {
+		split = 1
+	} else if extent.z > extent.x && extent.z > extent.y {
+		split = 2
+	}
+	slice := m.photons[lo:hi]
+	sort.Slice(slice, func(i, j int) bool { return axis(slice[i].position, split) < axis(slice[j].position, split) })
+	mid := (lo + hi) / 2
+	m.photons[mid].axis = split
+	m.build(lo, mid)
+	m.build(mid+1, hi)
+}
+
+// within appelle visit pour chaque photon à moins de sqrt(radius2) de p.
+// visit peut réduire radius2 en cours de recherche.
+func (m *photonMap) within(p Vec3f, radius2 *float32, visit func(i int, d2 float32)) {
+	var search func(lo, hi int)
+	search = func(lo, hi int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		ph := m.photons[mid]
+		d := Add(ph.position, p.inverte())
+		if d2 := Dot(d, d); d2 < *radius2 {
+			visit(mid, d2)
+		}
+		if hi-lo == 1 {
+			return
+		}
+		delta := axis(p, ph.axis) - axis(ph.position, ph.axis)
+		near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+		if delta > 0 {
+			near, far = far, near
+		}
+		search(near[0], near[1])
+		if delta*delta < *radius2 {
+			search(far[0], far[1])
+		}
+	}
+	search(0, len(m.photons))
+}
+
+type photonCandidate struct {
+	index int
+	dist2 float32
+}
+
+// nearestHeap : les k plus proches candidats, le plus lointain en tête.
+type nearestHeap []photonCandidate
+
+func (h nearestHeap) Len() int           { return len(h) }
+func (h nearestHeap) Less(i, j int) bool { return h[i].dist2 > h[j].dist2 }
+func (h nearestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nearestHeap) Push(x any)        { *h = append(*h, x.(photonCandidate)) }
+func (h *nearestHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// nearest renvoie les indices des k photons les plus proches de p, à moins
+// de maxRadius, et le carré de la distance du plus lointain.
+func (m *photonMap) nearest(p Vec3f, k int, maxRadius float32) ([]int, float32) {
+	radius2 := maxRadius * maxRadius
+	h := &nearestHeap{}
+	m.within(p, &radius2, func(i int, d2 float32) {
+		if h.Len() < k {
+			heap.Push(h, photonCandidate{i, d2})
+		} else if d2 < (*h)[0].dist2 {
+			(*h)[0] = photonCandidate{i, d2}
+			heap.Fix(h, 0)
+		}
+		if h.Len() == k {
+			radius2 = (*h)[0].dist2
+		}
+	})
+	indices := make([]int, h.Len())
+	for i, c := range *h {
+		indices[i] = c.index
+	}
+	return indices, radius2
+}
+
+// gather somme f · flux des photons d'indices donnés arrivés du côté de la
+// normale.
+func (m *photonMap) gather(b BSDF, hit HitRecord, wo Vec3f, indices []int) Vec3f {
+	var sum Vec3f
+	for _, i := range indices {
+		ph := m.photons[i]
+		cos := Dot(hit.shadingNormal, ph.direction)
+		if cos <= 0 {
+			continue
+		}
+		sum = Add(sum, Mul(b.eval(hit, wo, ph.direction).mul(1/cos), ph.power))
+	}
+	return sum
+}
+
+// ------------------------------
+// PhotonMapper : tracé de chemins complété par une carte de caustiques,
+// estimée avec les nearest photons les plus proches dans un rayon radius.
+// La carte est construite par prepare sur chaque machine, avec la même
+// graine : les tuiles du rendu distribué s'accordent.
+type PhotonMapper struct {
+	maxDepth int
+	photons  int
+	nearest  int
+	radius   float32
+
+	causticMap *photonMap
+}
+
+func NewPhotonMapper(maxDepth, photons, nearest int, radius float32) PhotonMapper {
+	return PhotonMapper{maxDepth: maxDepth, photons: photons, nearest: max(nearest, 1), radius: radius}
+}
+
+// preparedIntegrator : intégrateur qui calcule ses données pour une scène
+// préparée avant le rendu.
+type preparedIntegrator interface {
+	prepare(scene Scene) Integrator
+}
+
+func (m PhotonMapper) prepare(scene Scene) Integrator {
+	m.causticMap = newPhotonMap(tracePhotons(scene, m.photons, 1))
+	return m
+}
+
+func (m PhotonMapper) caustics(b BSDF, hit HitRecord, wo Vec3f) Vec3f {
+	if m.causticMap == nil || len(m.causticMap.photons) == 0 {
+		return Vec3f{}
+	}
+	indices, radius2 := m.causticMap.nearest(hit.position, m.nearest, m.radius)
+	if len(indices) == 0 {
+		return Vec3f{}
+	}
+	return m.causticMap.gather(b, hit, wo, indices).mul(1 / (math.Pi * radius2))
+}
+
+func (m PhotonMapper) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	return PathTracer{m.maxDepth}.trace(scene, ray, rng, m)
+}
+
+// ------------------------------
+// ProgressivePhotonMapper : photon mapping progressif probabiliste (Knaus et
+// Zwicker 2011). Chaque passe trace ses propres photons et les rassemble
+// dans un rayon fixe qui décroît d'une passe à l'autre,
+// r²ᵢ₊₁ = r²ᵢ (i + alpha) / (i + 1) ; la moyenne des passes converge vers
+// la solution exacte. Chaque passe a sa propre graine : en rendu distribué,
+// chaque client trace une plage de passes (split) et le serveur fait la
+// moyenne des images.
+type ProgressivePhotonMapper struct {
+	maxDepth  int
+	photons   int // par passe
+	firstPass int
+	passes    int
+	radius    float32 // rayon de la première passe
+	alpha     float32
+
+	maps  []*photonMap
+	radii []float32
+}
+
+func NewProgressivePhotonMapper(maxDepth, photons, passes int, radius float32) ProgressivePhotonMapper {
+	return ProgressivePhotonMapper{maxDepth: maxDepth, photons: photons, passes: max(passes, 1), radius: radius, alpha: 2.0 / 3}
+}
+
+// passRadius2 : carré du rayon de la passe i (à partir de 0).
+func (m ProgressivePhotonMapper) passRadius2(i int) float32 {
+	r2 := m.radius * m.radius
+	for k := 1; k <= i; k++ {
+		r2 *= (float32(k) + m.alpha) / float32(k+1)
+	}
+	return r2
+}
+
+// split répartit les passes en au plus parts plages consécutives.
+func (m ProgressivePhotonMapper) split(parts int) []ProgressivePhotonMapper {
+	parts = min(parts, m.passes)
+	var res []ProgressivePhotonMapper
+	for k := 0; k < parts; k++ {
+		part := m
+		part.firstPass = m.firstPass + k*m.passes/parts
+		part.passes = m.firstPass + (k+1)*m.passes/parts - part.firstPass
+		res = append(res, part)
+	}
+	return res
+}
+
+func (m ProgressivePhotonMapper) prepare(scene Scene) Integrator {
+	m.maps, m.radii = nil, nil
+	for i := m.firstPass; i < m.firstPass+m.passes; i++ {
+		m.maps = append(m.maps, newPhotonMap(tracePhotons(scene, m.photons, uint64(i)+1)))
+		m.radii = append(m.radii, m.passRadius2(i))
+	}
+	return m
+}
+
+func (m ProgressivePhotonMapper) caustics(b BSDF, hit HitRecord, wo Vec3f) Vec3f {
+	var sum Vec3f
+	for i, pm := range m.maps {
+		radius2 := m.radii[i]
+		var indices []int
+		pm.within(hit.position, &radius2, func(j int, d2 float32) { indices = append(indices, j) })
+		sum = Add(sum, pm.gather(b, hit, wo, indices).mul(1/(math.Pi*radius2)))
+	}
+	return sum.mul(1 / float32(max(len(m.maps), 1)))
+}
+
+func (m ProgressivePhotonMapper) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	return PathTracer{m.maxDepth}.trace(scene, ray, rng, m)
+}
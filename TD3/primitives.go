@@ -0,0 +1,740 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Primitives analytiques. Chacune donne sa normale sortante, ses
+// coordonnées de texture (uvAt) et sa boîte englobante.
+
+const hitEpsilon = 1e-4
+
+// orthonormalBasis complète n en une base orthonormée (Duff et al. 2017).
+func orthonormalBasis(n Vec3f) (Vec3f, Vec3f) {
+	sign := float32(math.Copysign(1, float64(n.z)))
+	a := -1 / (sign + n.z)
+	b := n.x * n.y * a
+	return Vec3f{1 + sign*n.x*n.x*a, sign * b, -sign * n.x}, Vec3f{b, sign + n.y*n.y*a, -n.y}
+}
+
+// nearestRoot renvoie la plus petite racine dans ]tmin, tmax[.
+func nearestRoot(tmin, tmax float32, roots ...float32) (bool, float32) {
+	found := false
+	for _, t := range roots {
+		if t > tmin && t < tmax {
+			found, tmax = true, t
+		}
+	}
+	return found, tmax
+}
+
+// Ce que fournit chaque primitive analytique pour construire son HitRecord
+type analytic interface {
+	hitDistance(ray Ray, tmin, tmax float32) (bool, float32)
+	normalAt(p Vec3f) Vec3f
+	uvAt(p Vec3f) Vec2f
+	tangentAt(p Vec3f) Vec3f
+	material() Materials
+}
+
+func intersectAnalytic(a analytic, ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	ok, t := a.hitDistance(ray, tmin, tmax)
+	if !ok {
+		return HitRecord{}, false
+	}
+	p := ray.at(t)
+	hit := newHit(ray, t, a.normalAt(p), a.uvAt(p), a.material())
+	hit.tangent = a.tangentAt(p)
+	return hit, true
+}
+
+func solveQuadratic(a, b, c float32) (bool, float32, float32) {
+	delta := b*b - 4*a*c
+	if delta < 0 || a == 0 {
+		return false, 0, 0
+	}
+	sq := float32(math.Sqrt(float64(delta)))
+	return true, (-b - sq) / (2 * a), (-b + sq) / (2 * a)
+}
+
+// Boîte englobante d'un disque de centre c, de normale n et de rayon r
+func diskBounds(c, n Vec3f, r float32) Bounds {
+	e := Vec3f{
+		r * float32(math.Sqrt(float64(max(0, 1-n.x*n.x)))),
+		r * float32(math.Sqrt(float64(max(0, 1-n.y*n.y)))),
+		r * float32(math.Sqrt(float64(max(0, 1-n.z*n.z)))),
+	}
+	return Bounds{Add(c, e.inverte()), Add(c, e)}
+}
+
+func polarUV(q, tu, tv Vec3f, radius float32) Vec2f {
+	angle := math.Atan2(float64(Dot(q, tv)), float64(Dot(q, tu)))
+	return Vec2f{q.norme() / radius, float32(angle/(2*math.Pi)) + 0.5}
+}
+
+// ------------------------------
+// Plan infini passant par point, de normale normal
+type Plane struct {
+	point, normal Vec3f
+	Material      Materials
+}
+
+func NewPlane(point, normal Vec3f, material Materials) Plane {
+	return Plane{point, normal.normalized(), material}
+}
+
+func (p Plane) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	denom := Dot(p.normal, ray.direction)
+	if denom > -1e-8 && denom < 1e-8 {
+		return false, 0
+	}
+	return nearestRoot(tmin, tmax, Dot(Add(p.point, ray.origin.inverte()), p.normal)/denom)
+}
+
+func (p Plane) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(p, ray, tmin, tmax)
+}
+
+func (p Plane) normalAt(pos Vec3f) Vec3f { return p.normal }
+func (p Plane) material() Materials      { return p.Material }
+func (p Plane) bounds() Bounds           { return infiniteBounds() }
+
+// Coordonnées planaires, une unité de texture par unité de scène
+func (p Plane) uvAt(pos Vec3f) Vec2f {
+	tu, tv := orthonormalBasis(p.normal)
+	q := Add(pos, p.point.inverte())
+	return Vec2f{Dot(q, tu), Dot(q, tv)}
+}
+
+func (p Plane) tangentAt(pos Vec3f) Vec3f {
+	tu, _ := orthonormalBasis(p.normal)
+	return tu
+}
+
+func (p Plane) withMaterial(m Materials) GeometricObject {
+	p.Material = m
+	return p
+}
+
+// ------------------------------
+type Disk struct {
+	center, normal Vec3f
+	radius         float32
+	Material       Materials
+}
+
+func NewDisk(center, normal Vec3f, radius float32, material Materials) Disk {
+	return Disk{center, normal.normalized(), radius, material}
+}
+
+func (d Disk) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	ok, t := Plane{d.center, d.normal, nil}.hitDistance(ray, tmin, tmax)
+	if !ok {
+		return false, 0
+	}
+	q := Add(ray.at(t), d.center.inverte())
+	return Dot(q, q) <= d.radius*d.radius, t
+}
+
+func (d Disk) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(d, ray, tmin, tmax)
+}
+
+func (d Disk) normalAt(pos Vec3f) Vec3f { return d.normal }
+func (d Disk) material() Materials      { return d.Material }
+func (d Disk) bounds() Bounds           { return diskBounds(d.center, d.normal, d.radius) }
+
+func (d Disk) uvAt(pos Vec3f) Vec2f {
+	tu, tv := orthonormalBasis(d.normal)
+	return polarUV(Add(pos, d.center.inverte()), tu, tv, d.radius)
+}
+
+// u croît avec la distance au centre
+func (d Disk) tangentAt(pos Vec3f) Vec3f {
+	q := Add(pos, d.center.inverte())
+	if q.norme() == 0 {
+		tu, _ := orthonormalBasis(d.normal)
+		return tu
+	}
+	return q.normalized().mul(d.radius)
+}
+
+func (d Disk) withMaterial(m Materials) GeometricObject {
+	d.Material = m
+	return d
+}
+
+// ------------------------------
+// Rectangle (parallélogramme) de coin corner et de côtés edgeU, edgeV. Sa
+// normale est cross(edgeU, edgeV).
+type Rectangle struct {
+	corner, edgeU, edgeV Vec3f
+	Material             Materials
+}
+
+func NewRectangle(corner, edgeU, edgeV Vec3f, material Materials) Rectangle {
+	return Rectangle{corner, edgeU, edgeV, material}
+}
+
+// params renvoie les coordonnées de p dans la base (edgeU, edgeV).
+func (r Rectangle) params(p Vec3f) (float32, float32) {
+	q := Add(p, r.corner.inverte())
+	uu, uv, vv := Dot(r.edgeU, r.edgeU), Dot(r.edgeU, r.edgeV), Dot(r.edgeV, r.edgeV)
+	qu, qv := Dot(q, r.edgeU), Dot(q, r.edgeV)
+	det := uu*vv - uv*uv
+	return (qu*vv - qv*uv) / det, (qv*uu - qu*uv) / det
+}
+
+func (r Rectangle) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	ok, t := Plane{r.corner, r.normalAt(r.corner), nil}.hitDistance(ray, tmin, tmax)
+	if !ok {
+		return false, 0
+	}
+	u, v := r.params(ray.at(t))
+	return u >= 0 && u <= 1 && v >= 0 && v <= 1, t
+}
+
+func (r Rectangle) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(r, ray, tmin, tmax)
+}
+
+func (r Rectangle) normalAt(pos Vec3f) Vec3f  { return cross(r.edgeU, r.edgeV).normalized() }
+func (r Rectangle) tangentAt(pos Vec3f) Vec3f { return r.edgeU }
+func (r Rectangle) material() Materials       { return r.Material }
+
+func (r Rectangle) uvAt(pos Vec3f) Vec2f {
+	u, v := r.params(pos)
+	return Vec2f{u, v}
+}
+
+func (r Rectangle) bounds() Bounds {
+	return emptyBounds().addPoint(r.corner).addPoint(Add(r.corner, r.edgeU)).
+		addPoint(Add(r.corner, r.edgeV)).addPoint(Add(Add(r.corner, r.edgeU), r.edgeV))
+}
+
+func (r Rectangle) withMaterial(m Materials) GeometricObject {
+	r.Material = m
+	return r
+}
+
+// ------------------------------
+// Fonctions communes aux boîtes, dans le repère de la boîte
+
+func slabs(origin, direction, bmin, bmax Vec3f, tmin, tmax float32) (bool, float32) {
+	ok, tnear, tfar := clipBox(origin, direction, bmin, bmax)
+	if !ok {
+		return false, 0
+	}
+	return nearestRoot(tmin, tmax, tnear, tfar)
+}
+
+// clipBox renvoie les distances d'entrée et de sortie de la droite dans la boîte.
+func clipBox(origin, direction, bmin, bmax Vec3f) (bool, float32, float32) {
+	tnear := float32(math.Inf(-1))
+	tfar := float32(math.Inf(1))
+	for a := 0; a < 3; a++ {
+		invD := 1 / axis(direction, a)
+		t0 := (axis(bmin, a) - axis(origin, a)) * invD
+		t1 := (axis(bmax, a) - axis(origin, a)) * invD
+		if invD < 0 {
+			t0, t1 = t1, t0
+		}
+		tnear = max(tnear, t0)
+		tfar = min(tfar, t1)
+	}
+	return tfar >= tnear, tnear, tfar
+}
+
+// boxFace renvoie l'axe (0, 1, 2) et le signe de la face la plus proche de p.
+func boxFace(p, bmin, bmax Vec3f) (int, float32) {
+	center := Add(bmin, bmax).mul(0.5)
+	half := Add(bmax, bmin.inverte()).mul(0.5)
+	best, sign := 0, float32(1)
+	bestDist := float32(math.MaxFloat32)
+	for a := 0; a < 3; a++ {
+		d := (axis(p, a) - axis(center, a)) / axis(half, a)
+		if dist := 1 - float32(math.Abs(float64(d))); dist < bestDist {
+			best, bestDist = a, dist
+			sign = float32(math.Copysign(1, float64(d)))
+		}
+	}
+	return best, sign
+}
+
+func axisVector(a int, sign float32) Vec3f {
+	switch a {
+	case 0:
+		return Vec3f{sign, 0, 0}
+	case 1:
+		return Vec3f{0, sign, 0}
+	}
+	return Vec3f{0, 0, sign}
+}
+
+// boxTangentAxis renvoie l'axe le long duquel croît u sur la face de p.
+func boxTangentAxis(p, bmin, bmax Vec3f) int {
+	if face, _ := boxFace(p, bmin, bmax); face == 0 {
+		return 2
+	}
+	return 0
+}
+
+// Chaque face est projetée sur [0,1]², à partir des deux autres axes
+func boxUV(p, bmin, bmax Vec3f) Vec2f {
+	face, _ := boxFace(p, bmin, bmax)
+	rel := func(a int) float32 {
+		return (axis(p, a) - axis(bmin, a)) / (axis(bmax, a) - axis(bmin, a))
+	}
+	switch face {
+	case 0:
+		return Vec2f{rel(2), rel(1)}
+	case 1:
+		return Vec2f{rel(0), rel(2)}
+	}
+	return Vec2f{rel(0), rel(1)}
+}
+
+// ------------------------------
+// Boîte alignée sur les axes
+type Box struct {
+	min, max Vec3f
+	Material Materials
+}
+
+func (b Box) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	return slabs(ray.origin, ray.direction, b.min, b.max, tmin, tmax)
+}
+
+func (b Box) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(b, ray, tmin, tmax)
+}
+
+func (b Box) normalAt(p Vec3f) Vec3f {
+	return axisVector(boxFace(p, b.min, b.max))
+}
+
+func (b Box) material() Materials  { return b.Material }
+func (b Box) bounds() Bounds       { return Bounds{b.min, b.max} }
+func (b Box) uvAt(pos Vec3f) Vec2f { return boxUV(pos, b.min, b.max) }
+
+func (b Box) tangentAt(pos Vec3f) Vec3f { return axisVector(boxTangentAxis(pos, b.min, b.max), 1) }
+
+func (b Box) withMaterial(m Materials) GeometricObject {
+	b.Material = m
+	return b
+}
+
+// ------------------------------
+// Boîte orientée : demi-dimensions halfSize le long des axes orthonormés axes
+type OrientedBox struct {
+	center, halfSize Vec3f
+	axes             [3]Vec3f
+	Material         Materials
+}
+
+// NewOrientedBox prend les axes de la boîte dans les colonnes de la rotation.
+func NewOrientedBox(center, size Vec3f, orientation Matrix4, material Materials) OrientedBox {
+	var axes [3]Vec3f
+	for a := 0; a < 3; a++ {
+		axes[a] = orientation.transformVector(axisVector(a, 1)).normalized()
+	}
+	return OrientedBox{center, size.mul(0.5), axes, material}
+}
+
+func (b OrientedBox) toLocal(v Vec3f) Vec3f {
+	return Vec3f{Dot(v, b.axes[0]), Dot(v, b.axes[1]), Dot(v, b.axes[2])}
+}
+
+func (b OrientedBox) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	origin := b.toLocal(Add(ray.origin, b.center.inverte()))
+	return slabs(origin, b.toLocal(ray.direction), b.halfSize.inverte(), b.halfSize, tmin, tmax)
+}
+
+func (b OrientedBox) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(b, ray, tmin, tmax)
+}
+
+func (b OrientedBox) normalAt(p Vec3f) Vec3f {
+	a, sign := boxFace(b.toLocal(Add(p, b.center.inverte())), b.halfSize.inverte(), b.halfSize)
+	return b.axes[a].mul(sign)
+}
+
+func (b OrientedBox) uvAt(pos Vec3f) Vec2f {
+	return boxUV(b.toLocal(Add(pos, b.center.inverte())), b.halfSize.inverte(), b.halfSize)
+}
+
+func (b OrientedBox) tangentAt(pos Vec3f) Vec3f {
+	return b.axes[boxTangentAxis(b.toLocal(Add(pos, b.center.inverte())), b.halfSize.inverte(), b.halfSize)]
+}
+
+func (b OrientedBox) material() Materials { return b.Material }
+
+func (b OrientedBox) bounds() Bounds {
+	e := Vec3f{}
+	for a := 0; a < 3; a++ {
+		h := b.axes[a].mul(axis(b.halfSize, a))
+		e = Add(e, Vec3f{float32(math.Abs(float64(h.x))), float32(math.Abs(float64(h.y))), float32(math.Abs(float64(h.z)))})
+	}
+	return Bounds{Add(b.center, e.inverte()), Add(b.center, e)}
+}
+
+func (b OrientedBox) withMaterial(m Materials) GeometricObject {
+	b.Material = m
+	return b
+}
+
+// ------------------------------
+// Cylindre fermé : de base à base + axis*height
+type Cylinder struct {
+	base, axis     Vec3f
+	radius, height float32
+	Material       Materials
+}
+
+func NewCylinder(base, axis Vec3f, radius, height float32, material Materials) Cylinder {
+	return Cylinder{base, axis.normalized(), radius, height, material}
+}
+
+func (c Cylinder) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	o := Add(ray.origin, c.base.inverte())
+	oa, da := Dot(o, c.axis), Dot(ray.direction, c.axis)
+	oPerp := Add(o, c.axis.mul(-oa))
+	dPerp := Add(ray.direction, c.axis.mul(-da))
+
+	var candidates []float32
+	if ok, t0, t1 := solveQuadratic(Dot(dPerp, dPerp), 2*Dot(oPerp, dPerp), Dot(oPerp, oPerp)-c.radius*c.radius); ok {
+		for _, t := range []float32{t0, t1} {
+			if h := oa + t*da; h >= 0 && h <= c.height {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	if da != 0 {
+		for _, h := range []float32{0, c.height} {
+			t := (h - oa) / da
+			q := Add(oPerp, dPerp.mul(t))
+			if Dot(q, q) <= c.radius*c.radius {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	return nearestRoot(tmin, tmax, candidates...)
+}
+
+func (c Cylinder) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(c, ray, tmin, tmax)
+}
+
+func (c Cylinder) normalAt(p Vec3f) Vec3f {
+	q := Add(p, c.base.inverte())
+	h := Dot(q, c.axis)
+	radial := Add(q, c.axis.mul(-h))
+	switch {
+	case h < hitEpsilon && radial.norme() < c.radius-hitEpsilon:
+		return c.axis.inverte()
+	case h > c.height-hitEpsilon && radial.norme() < c.radius-hitEpsilon:
+		return c.axis
+	}
+	return radial.normalized()
+}
+
+// u : angle autour de l'axe, v : hauteur, disques compris (v y vaut 0 ou 1).
+func (c Cylinder) uvAt(pos Vec3f) Vec2f {
+	tu, tv := orthonormalBasis(c.axis)
+	q := Add(pos, c.base.inverte())
+	h := Dot(q, c.axis)
+	uv := polarUV(Add(q, c.axis.mul(-h)), tu, tv, c.radius)
+	return Vec2f{uv.y, h / c.height}
+}
+
+// u croît en tournant autour de l'axe
+func (c Cylinder) tangentAt(pos Vec3f) Vec3f {
+	return cross(c.axis, Add(pos, c.base.inverte()))
+}
+
+func (c Cylinder) material() Materials { return c.Material }
+
+func (c Cylinder) bounds() Bounds {
+	return diskBounds(c.base, c.axis, c.radius).union(diskBounds(Add(c.base, c.axis.mul(c.height)), c.axis, c.radius))
+}
+
+func (c Cylinder) withMaterial(m Materials) GeometricObject {
+	c.Material = m
+	return c
+}
+
+// ------------------------------
+// Cône fermé : disque de rayon radius en base, sommet en base + axis*height
+type Cone struct {
+	base, axis     Vec3f
+	radius, height float32
+	Material       Materials
+}
+
+func NewCone(base, axis Vec3f, radius, height float32, material Materials) Cone {
+	return Cone{base, axis.normalized(), radius, height, material}
+}
+
+func (c Cone) apex() Vec3f {
+	return Add(c.base, c.axis.mul(c.height))
+}
+
+func (c Cone) cos2() float32 {
+	return c.height * c.height / (c.height*c.height + c.radius*c.radius)
+}
+
+func (c Cone) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	// Depuis le sommet, D pointe vers la base
+	D := c.axis.inverte()
+	co := Add(ray.origin, c.apex().inverte())
+	dv, cv := Dot(ray.direction, D), Dot(co, D)
+	cos2 := c.cos2()
+
+	var candidates []float32
+	a := dv*dv - cos2*Dot(ray.direction, ray.direction)
+	b := 2 * (dv*cv - cos2*Dot(ray.direction, co))
+	cc := cv*cv - cos2*Dot(co, co)
+	if ok, t0, t1 := solveQuadratic(a, b, cc); ok {
+		for _, t := range []float32{t0, t1} {
+			if h := cv + t*dv; h >= 0 && h <= c.height {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	if ok, t := (Disk{c.base, c.axis, c.radius, nil}).hitDistance(ray, tmin, tmax); ok {
+		candidates = append(candidates, t)
+	}
+	return nearestRoot(tmin, tmax, candidates...)
+}
+
+func (c Cone) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(c, ray, tmin, tmax)
+}
+
+func (c Cone) normalAt(p Vec3f) Vec3f {
+	if h := Dot(Add(p, c.base.inverte()), c.axis); h < hitEpsilon {
+		return c.axis.inverte()
+	}
+	D := c.axis.inverte()
+	q := Add(p, c.apex().inverte())
+	return Add(q.mul(c.cos2()), D.mul(-Dot(q, D))).normalized()
+}
+
+func (c Cone) uvAt(pos Vec3f) Vec2f {
+	tu, tv := orthonormalBasis(c.axis)
+	q := Add(pos, c.base.inverte())
+	h := Dot(q, c.axis)
+	uv := polarUV(Add(q, c.axis.mul(-h)), tu, tv, c.radius)
+	return Vec2f{uv.y, h / c.height}
+}
+
+func (c Cone) tangentAt(pos Vec3f) Vec3f {
+	return cross(c.axis, Add(pos, c.base.inverte()))
+}
+
+func (c Cone) material() Materials { return c.Material }
+
+func (c Cone) bounds() Bounds {
+	return diskBounds(c.base, c.axis, c.radius).addPoint(c.apex())
+}
+
+func (c Cone) withMaterial(m Materials) GeometricObject {
+	c.Material = m
+	return c
+}
+
+// ------------------------------
+// Tore de centre center, d'axe axis, de grand rayon major et de petit rayon minor
+type Torus struct {
+	center, axis Vec3f
+	major, minor float32
+	Material     Materials
+}
+
+func NewTorus(center, axis Vec3f, major, minor float32, material Materials) Torus {
+	return Torus{center, axis.normalized(), major, minor, material}
+}
+
+// toLocal exprime v dans un repère où l'axe du tore est y.
+func (to Torus) toLocal(v Vec3f) Vec3f {
+	tu, tv := orthonormalBasis(to.axis)
+	return Vec3f{Dot(v, tu), Dot(v, to.axis), Dot(v, tv)}
+}
+
+func (to Torus) hitDistance(ray Ray, tmin, tmax float32) (bool, float32) {
+	if ok, _ := to.bounds().hit(ray, tmax); !ok {
+		return false, 0
+	}
+
+	o := to.toLocal(Add(ray.origin, to.center.inverte()))
+	d := to.toLocal(ray.direction)
+	// Direction normalisée pour la stabilité numérique, t est remis à l'échelle ensuite
+	length := d.norme()
+	d = d.mul(1 / length)
+
+	ox, oy, oz := float64(o.x), float64(o.y), float64(o.z)
+	dx, dy, dz := float64(d.x), float64(d.y), float64(d.z)
+	R2 := float64(to.major * to.major)
+	r2 := float64(to.minor * to.minor)
+
+	// (|p|² + R² - r²)² - 4R²(px² + pz²) = 0 avec p = o + t d et |d| = 1
+	H := 2 * (ox*dx + oy*dy + oz*dz)
+	I := ox*ox + oy*oy + oz*oz + R2 - r2
+	J := dx*dx + dz*dz
+	K := 2 * (ox*dx + oz*dz)
+	L := ox*ox + oz*oz
+	coeffs := [5]float64{I*I - 4*R2*L, 2*H*I - 4*R2*K, H*H + 2*I - 4*R2*J, 2 * H, 1}
+
+	var candidates []float32
+	for _, root := range solveQuartic(coeffs) {
+		candidates = append(candidates, float32(root)/length)
+	}
+	return nearestRoot(tmin, tmax, candidates...)
+}
+
+func (to Torus) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	return intersectAnalytic(to, ray, tmin, tmax)
+}
+
+func (to Torus) normalAt(p Vec3f) Vec3f {
+	q := Add(p, to.center.inverte())
+	h := Dot(q, to.axis)
+	ring := Add(q, to.axis.mul(-h)).normalized().mul(to.major)
+	return Add(q, ring.inverte()).normalized()
+}
+
+// u : angle autour de l'axe, v : angle autour du tube
+func (to Torus) uvAt(pos Vec3f) Vec2f {
+	p := to.toLocal(Add(pos, to.center.inverte()))
+	ring := float32(math.Hypot(float64(p.x), float64(p.z)))
+	u := math.Atan2(float64(p.z), float64(p.x))/(2*math.Pi) + 0.5
+	v := math.Atan2(float64(p.y), float64(ring-to.major))/(2*math.Pi) + 0.5
+	return Vec2f{float32(u), float32(v)}
+}
+
+func (to Torus) tangentAt(pos Vec3f) Vec3f {
+	return cross(to.axis, Add(pos, to.center.inverte()))
+}
+
+func (to Torus) material() Materials { return to.Material }
+
+func (to Torus) bounds() Bounds {
+	return diskBounds(to.center, to.axis, to.major+to.minor).union(
+		diskBounds(Add(to.center, to.axis.mul(to.minor)), to.axis, to.major+to.minor)).union(
+		diskBounds(Add(to.center, to.axis.mul(-to.minor)), to.axis, to.major+to.minor))
+}
+
+func (to Torus) withMaterial(m Materials) GeometricObject {
+	to.Material = m
+	return to
+}
+
+// ------------------------------
+// Résolution des équations de degré 2 à 4 (Schwarze, Graphics Gems I).
+// Les coefficients sont donnés par degré croissant.
+
+func isZero(x float64) bool {
+	return x > -1e-9 && x < 1e-9
+}
+
+func solveQuadric(c [3]float64) []float64 {
+	p := c[1] / (2 * c[2])
+	q := c[0] / c[2]
+	D := p*p - q
+	if isZero(D) {
+		return []float64{-p}
+	}
+	if D < 0 {
+		return nil
+	}
+	sqrtD := math.Sqrt(D)
+	return []float64{sqrtD - p, -sqrtD - p}
+}
+
+func solveCubic(c [4]float64) []float64 {
+	A := c[2] / c[3]
+	B := c[1] / c[3]
+	C := c[0] / c[3]
+
+	sqA := A * A
+	p := 1.0 / 3 * (-1.0/3*sqA + B)
+	q := 1.0 / 2 * (2.0/27*A*sqA - 1.0/3*A*B + C)
+	cbP := p * p * p
+	D := q*q + cbP
+
+	var s []float64
+	switch {
+	case isZero(D):
+		if isZero(q) {
+			s = []float64{0}
+		} else {
+			u := math.Cbrt(-q)
+			s = []float64{2 * u, -u}
+		}
+	case D < 0:
+		phi := 1.0 / 3 * math.Acos(-q/math.Sqrt(-cbP))
+		t := 2 * math.Sqrt(-p)
+		s = []float64{t * math.Cos(phi), -t * math.Cos(phi+math.Pi/3), -t * math.Cos(phi-math.Pi/3)}
+	default:
+		sqrtD := math.Sqrt(D)
+		s = []float64{math.Cbrt(sqrtD-q) - math.Cbrt(sqrtD+q)}
+	}
+
+	for i := range s {
+		s[i] -= 1.0 / 3 * A
+	}
+	return s
+}
+
+func solveQuartic(c [5]float64) []float64 {
+	A := c[3] / c[4]
+	B := c[2] / c[4]
+	C := c[1] / c[4]
+	D := c[0] / c[4]
+
+	// x = y - A/4 : y^4 + p y^2 + q y + r = 0
+	sqA := A * A
+	p := -3.0/8*sqA + B
+	q := 1.0/8*sqA*A - 1.0/2*A*B + C
+	r := -3.0/256*sqA*sqA + 1.0/16*sqA*B - 1.0/4*A*C + D
+
+	var s []float64
+	if isZero(r) {
+		s = append(solveCubic([4]float64{q, p, 0, 1}), 0)
+	} else {
+		z := solveCubic([4]float64{1.0/2*r*p - 1.0/8*q*q, -r, -1.0 / 2 * p, 1})[0]
+		u := z*z - r
+		v := 2*z - p
+		if isZero(u) {
+			u = 0
+		} else if u > 0 {
+			u = math.Sqrt(u)
+		} else {
+			return nil
+		}
+		if isZero(v) {
+			v = 0
+		} else if v > 0 {
+			v = math.Sqrt(v)
+		} else {
+			return nil
+		}
+		if q < 0 {
+			v = -v
+		}
+		s = append(solveQuadric([3]float64{z - u, v, 1}), solveQuadric([3]float64{z + u, -v, 1})...)
+	}
+
+	// Retour en x, puis deux itérations de Newton sur le polynôme d'origine
+	for i := range s {
+		x := s[i] - 1.0/4*A
+		for k := 0; k < 2; k++ {
+			f := (((c[4]*x+c[3])*x+c[2])*x+c[1])*x + c[0]
+			df := ((4*c[4]*x+3*c[3])*x+2*c[2])*x + c[1]
+			if df != 0 {
+				x -= f / df
+			}
+		}
+		s[i] = x
+	}
+	return s
+}
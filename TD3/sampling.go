@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ------------------------------
+// Générateur pseudo-aléatoire PCG32, assez léger pour en créer un par pixel
+// et rendre les images reproductibles quel que soit le découpage en tuiles.
+type Rng struct {
+	state uint64
+}
+
+func NewRng(seed uint64) *Rng {
+	r := &Rng{}
+	r.next()
+	r.state += seed
+	r.next()
+	return r
+}
+
+// pixelRng dérive la graine des coordonnées du pixel et de celle du job.
+func pixelRng(x, y int, seed uint64) *Rng {
+	return NewRng(uint64(x)*0x9e3779b97f4a7c15 ^ uint64(y)*0xbf58476d1ce4e5b9 ^ seed*0x94d049bb133111eb)
+}
+
+func (r *Rng) next() uint32 {
+	old := r.state
+	r.state = old*6364136223846793005 + 1442695040888963407
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return (xorshifted >> rot) | (xorshifted << ((-rot) & 31))
+}
+
+// float renvoie un nombre uniforme dans [0, 1[.
+func (r *Rng) float() float32 {
+	return float32(r.next()>>8) / (1 << 24)
+}
+
+// ------------------------------
+// Changements de repère autour d'une normale
+
+// fromLocal exprime v, donné dans le repère (t, b, n), en coordonnées monde.
+func fromLocal(v, n Vec3f) Vec3f {
+	t, b := orthonormalBasis(n)
+	return Add(Add(t.mul(v.x), b.mul(v.y)), n.mul(v.z))
+}
+
+// cosineHemisphere tire une direction autour de n avec une densité
+// cos(θ) / π (méthode de Malley).
+func cosineHemisphere(n Vec3f, u1, u2 float32) Vec3f {
+	r := sqrt32(u1)
+	phi := 2 * math.Pi * float64(u2)
+	x, y := r*float32(math.Cos(phi)), r*float32(math.Sin(phi))
+	return fromLocal(Vec3f{x, y, sqrt32(max(0, 1-u1))}, n)
+}
+
+func reflect(v, n Vec3f) Vec3f {
+	return Add(v, n.mul(-2*Dot(v, n)))
+}
+
+func luminance(c Vec3f) float32 {
+	return 0.2126*c.x + 0.7152*c.y + 0.0722*c.z
+}
+
+// uniformSphere tire une direction uniforme sur la sphère (densité 1 / 4π).
+func uniformSphere(u1, u2 float32) Vec3f {
+	z := 1 - 2*u1
+	r := sqrt32(max(0, 1-z*z))
+	phi := 2 * math.Pi * float64(u2)
+	return Vec3f{r * float32(math.Cos(phi)), r * float32(math.Sin(phi)), z}
+}
+
+// uniformCone tire une direction uniforme dans le cône d'axe n et de
+// demi-angle de cosinus cosMax (densité 1 / 2π(1 - cosMax)).
+func uniformCone(n Vec3f, cosMax, u1, u2 float32) Vec3f {
+	cos := 1 - u1*(1-cosMax)
+	sin := sqrt32(max(0, 1-cos*cos))
+	phi := 2 * math.Pi * float64(u2)
+	return fromLocal(Vec3f{sin * float32(math.Cos(phi)), sin * float32(math.Sin(phi)), cos}, n)
+}
+
+// ------------------------------
+// distribution1D : tirage d'un indice proportionnellement à des poids
+// positifs, uniforme si tous les poids sont nuls.
+type distribution1D struct {
+	cdf []float32 // poids cumulés, normalisés
+}
+
+func newDistribution1D(weights []float32) distribution1D {
+	d := distribution1D{make([]float32, len(weights))}
+	total := float32(0)
+	for i, w := range weights {
+		total += max(w, 0)
+		d.cdf[i] = total
+	}
+	for i := range d.cdf {
+		if total > 0 {
+			d.cdf[i] /= total
+		} else {
+			d.cdf[i] = float32(i+1) / float32(len(d.cdf))
+		}
+	}
+	return d
+}
+
+// probability renvoie la probabilité de tirer l'indice i.
+func (d distribution1D) probability(i int) float32 {
+	if i > 0 {
+		return d.cdf[i] - d.cdf[i-1]
+	}
+	return d.cdf[0]
+}
+
+// sample renvoie l'indice tiré, u ramené dans [0, 1[ à l'intérieur de son
+// intervalle (réutilisable pour un second tirage) et sa probabilité.
+func (d distribution1D) sample(u float32) (int, float32, float32) {
+	i := min(sort.Search(len(d.cdf), func(i int) bool { return d.cdf[i] > u }), len(d.cdf)-1)
+	low := float32(0)
+	if i > 0 {
+		low = d.cdf[i-1]
+	}
+	p := d.cdf[i] - low
+	if p <= 0 {
+		return i, 0, 0
+	}
+	return i, clamp32((u-low)/p, 0, 0.99999994), p
+}
@@ -0,0 +1,929 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ------------------------------
+// Description JSON d'une scène :
+//
+//	{
+//	  "camera": {"position": [0, 0, -5], "up": [0, 1, 0], "at": [0, 0, 5]},
+//	  "textures": {"damier": {"type": "checker", "even": [1, 1, 1], "odd": [0, 0, 0], "scale": 8}},
+//	  "materials": {
+//	    "rouge": {"type": "phong", "color": [1, 0, 0], "specular": 0.8, "shininess": 32},
+//	    "or": {"type": "pbr", "color": [1, 0.77, 0.34], "metallic": 1, "roughness": 0.3},
+//	    "sol": {"type": "lambert", "textures": {"kd": "damier"}, "bumpMap": "damier", "bumpScale": 0.01}
+//	  },
+//	  "lights": [
+//	    {"position": [0, 10, 0], "color": [1, 1, 1]},
+//	    {"type": "spot", "position": [0, 5, 0], "direction": [0, -1, 0], "intensity": 40, "inner": 15, "outer": 25},
+//	    {"type": "directional", "direction": [-1, -1, 1], "color": [1, 0.95, 0.9], "intensity": 0.8}
+//	  ],
+//	  "lightSamples": 16,
+//	  "environment": {"type": "map", "image": "ciel.hdr", "intensity": 1, "rotation": 90},
+//	  "fog": {"scattering": 0.02, "color": [0.9, 0.9, 1], "falloff": 0.5},
+//	  "volumes": [
+//	    {"type": "homogeneous", "scattering": 1, "absorption": 0.1, "anisotropy": 0.6,
+//	     "shape": {"type": "sphere", "center": [2, 0, 8], "radius": 1}},
+//	    {"type": "grid", "file": "fumee.vol", "scattering": 8, "min": [-1, -1, 6], "max": [1, 1, 8]}
+//	  ],
+//	  "meshes": {"toit": {"vertices": [[-1, 0, 0], [1, 0, 0], [0, 1, 0]], "triangles": [[0, 1, 2]]}},
+//	  "objects": [
+//	    {"type": "mesh", "mesh": "toit", "transform": {"translate": [3, 0, 8]}},
+//	    {"type": "difference", "name": "lentille", "material": "rouge", "children": [
+//	      {"type": "sphere", "center": [0, 0, 8], "radius": 1},
+//	      {"type": "box", "min": [-1, -1, 7.5], "max": [1, 1, 9]}
+//	    ]},
+//	    {"type": "group", "name": "sol", "transform": {"translate": [0, -2, 0]}, "children": [
+//	      {"type": "plane", "point": [0, 0, 0], "normal": [0, 1, 0]}
+//	    ]},
+//	    {"type": "heightfield", "image": "relief.png", "min": [-10, -3, 0], "size": [20, 4, 20]}
+//	  ]
+//	}
+//
+// Les chemins de fichiers sont relatifs au fichier de scène.
+//
+// Les objets de premier niveau et les groupes deviennent des nœuds du graphe
+// de scène ; les opérandes d'une opération CSG restent de simples objets.
+
+type vec3 [3]float32
+
+func (v vec3) vec() Vec3f {
+	return Vec3f{v[0], v[1], v[2]}
+}
+
+type cameraDescription struct {
+	Position, Up, At vec3
+}
+
+type materialDescription struct {
+	Type      string
+	Color     vec3
+	Specular  float32
+	Shininess float32
+
+	// Matériau "emissive" : émet Color * Strength
+	Strength float32
+
+	// Matériau "pbr" (Cook-Torrance) : Color est la couleur de base
+	Metallic, Roughness float32
+
+	// Matériau "glass" : indice IOR (1.5 par défaut), Color teinte la
+	// lumière transmise (blanc par défaut). Abbe, nombre d'Abbe du verre
+	// (59 pour un verre crown, 36 pour un flint), le rend dispersif en rendu
+	// spectral.
+	IOR  float32
+	Abbe float32
+
+	// Matériaux "lambert" et "emissive" : spectre tabulé, couples
+	// [longueur d'onde en nm, valeur], qui remplace Color. La réflectance
+	// est entre 0 et 1 ; l'émission est ramenée à la luminance Strength.
+	Spectrum [][2]float32
+
+	// Matériau "subsurface" : Color vue de loin et libre parcours moyen
+	// Radius par canal, en unités de la scène (0.1 par défaut)
+	Radius vec3
+
+	// Matériau "toon" : Bands bandes de diffus, reflet Specular (exposant
+	// Shininess), liseré Rim sur la part RimWidth du bord, contour de couleur
+	// Outline et de OutlineWidth pixels (1 par défaut, 0 pour aucun)
+	Bands        int
+	Rim          float32
+	RimWidth     float32
+	Outline      vec3
+	OutlineWidth *int
+
+	// Textures associées aux paramètres ka, kd, ks et n (ou baseColor,
+	// metallic et roughness pour "pbr"), qui multiplient leur facteur. Un
+	// facteur nul vaut 1 quand une texture lui est associée ; ka suit kd
+	// s'il n'a pas sa propre texture.
+	Textures map[string]string
+
+	// Cartes de normales ou de relief, valables pour tout type de matériau
+	NormalMap, BumpMap string
+	BumpScale          float32
+
+	// Matériau "terrain" : couches mêlées selon l'altitude et la pente
+	Layers                  []layerDescription
+	HeightBlend, SlopeBlend float32
+}
+
+// Une couche s'applique entre Height[0] et Height[1] (altitudes monde) et
+// entre Slope[0] et Slope[1] (degrés) ; un intervalle absent ne limite rien.
+type layerDescription struct {
+	Material      string
+	Height, Slope *[2]float32
+}
+
+// Texture : image (file, wrap "repeat", "clamp" ou "mirror", filter
+// "bilinear" ou "nearest"), checker (even, odd, scale) ou noise (noise
+// "perlin", "simplex" ou "worley", seed, scale, octaves, low, high).
+type textureDescription struct {
+	Type         string
+	File         string
+	Wrap, Filter string
+	Even, Odd    vec3
+	Noise        string
+	Seed         int64
+	Scale        float32
+	Octaves      int
+	Low, High    vec3
+}
+
+type noiseDescription struct {
+	Seed      int64
+	Octaves   int
+	Frequency float32
+}
+
+// Sans type, une source ponctuelle sans atténuation. Les types "point" et
+// "spot" ont une intensité en candelas atténuée en 1/d², sauf si
+// Attenuation donne les coefficients constant, linéaire et quadratique ;
+// "directional" a un éclairement en lux. Les angles du cône sont des
+// demi-angles en degrés.
+type lightDescription struct {
+	Type                       string
+	Position, Color, Direction vec3
+	Intensity                  *float32
+	Inner, Outer, Falloff      float32
+	Attenuation                *[3]float32
+}
+
+func (l lightDescription) build() (LightSource, error) {
+	intensity := float32(1)
+	if l.Intensity != nil {
+		intensity = *l.Intensity
+	}
+	attenuation := InverseSquare
+	if l.Attenuation != nil {
+		attenuation = Attenuation{l.Attenuation[0], l.Attenuation[1], l.Attenuation[2]}
+	}
+	switch l.Type {
+	case "":
+		return Light{l.Color.vec(), l.Position.vec()}, nil
+	case "point":
+		light := NewPointLight(l.Position.vec(), l.Color.vec(), intensity)
+		light.attenuation = attenuation
+		return light, nil
+	case "spot":
+		if l.Direction == (vec3{}) {
+			return nil, fmt.Errorf("spot light needs a direction")
+		}
+		if l.Outer <= 0 || l.Inner > l.Outer {
+			return nil, fmt.Errorf("spot light needs 0 <= inner <= outer, outer > 0")
+		}
+		light := NewSpotLight(l.Position.vec(), l.Direction.vec(), l.Color.vec(), intensity,
+			l.Inner*math.Pi/180, l.Outer*math.Pi/180)
+		if l.Falloff > 0 {
+			light.falloff = l.Falloff
+		}
+		light.attenuation = attenuation
+		return light, nil
+	case "directional":
+		if l.Direction == (vec3{}) {
+			return nil, fmt.Errorf("directional light needs a direction")
+		}
+		return NewDirectionalLight(l.Direction.vec(), l.Color.vec(), intensity), nil
+	}
+	return nil, fmt.Errorf("unknown light type %q", l.Type)
+}
+
+// Environnement : "constant" (Color), "gradient" (Zenith, Horizon, Ground),
+// "sunsky" (direction du soleil Sun, Turbidity, éclairement Intensity,
+// SkyIntensity, Ground) ou "map" (Image équirectangulaire .hdr, .png ou
+// .jpg, Intensity, Rotation en degrés autour de Y).
+type environmentDescription struct {
+	Type                    string
+	Color, Zenith, Horizon  vec3
+	Ground, Sun             *vec3
+	Turbidity               float32
+	Intensity, SkyIntensity *float32
+	Rotation                float32
+	Image                   string
+}
+
+func (b sceneBuilder) environment(e environmentDescription) (Environment, error) {
+	intensity := float32(1)
+	if e.Intensity != nil {
+		intensity = *e.Intensity
+	}
+	switch e.Type {
+	case "constant":
+		return ConstantEnvironment{e.Color.vec()}, nil
+	case "gradient":
+		ground := e.Horizon
+		if e.Ground != nil {
+			ground = *e.Ground
+		}
+		return GradientEnvironment{e.Zenith.vec(), e.Horizon.vec(), ground.vec()}, nil
+	case "sunsky":
+		if e.Sun == nil || *e.Sun == (vec3{}) {
+			return nil, fmt.Errorf("sunsky environment needs a sun direction")
+		}
+		turbidity := e.Turbidity
+		if turbidity == 0 {
+			turbidity = 3
+		}
+		sky := NewSunSky(e.Sun.vec(), turbidity, intensity)
+		if e.SkyIntensity != nil {
+			sky.skyIntensity = *e.SkyIntensity
+		}
+		if e.Ground != nil {
+			sky.ground = e.Ground.vec()
+		}
+		return sky, nil
+	case "map":
+		if e.Image == "" {
+			return nil, fmt.Errorf("map environment needs an image")
+		}
+		env, err := LoadEnvironmentMap(b.path(e.Image), intensity)
+		if err != nil {
+			return nil, err
+		}
+		env.rotation = e.Rotation * math.Pi / 180
+		return env, nil
+	}
+	return nil, fmt.Errorf("unknown environment type %q", e.Type)
+}
+
+// Milieu participant : coefficients d'absorption et de diffusion par unité
+// de longueur, teinte de la lumière diffusée (blanche par défaut) et
+// anisotropie g de Henyey-Greenstein. Le brouillard peut s'amincir au-dessus
+// de Base selon Falloff ; un volume "homogeneous" est délimité par l'objet
+// Shape, un volume "grid" par la boîte du fichier .vol, ou Min et Max.
+type mediumDescription struct {
+	Type                   string
+	Absorption, Scattering float32
+	Color                  *vec3
+	Anisotropy             float32
+	Base, Falloff          float32
+	Shape                  *objectDescription
+	File                   string
+	Min, Max               *vec3
+}
+
+// color valide les coefficients du milieu et renvoie sa couleur, blanche
+// par défaut.
+func (m mediumDescription) color() (Vec3f, error) {
+	if m.Absorption < 0 || m.Scattering < 0 {
+		return Vec3f{}, fmt.Errorf("negative medium coefficient")
+	}
+	if m.Anisotropy <= -1 || m.Anisotropy >= 1 {
+		return Vec3f{}, fmt.Errorf("anisotropy must be in ]-1, 1[")
+	}
+	if m.Color == nil {
+		return Vec3f{1, 1, 1}, nil
+	}
+	return m.Color.vec(), nil
+}
+
+func (b sceneBuilder) fog(m mediumDescription) (Medium, error) {
+	color, err := m.color()
+	if err != nil {
+		return nil, err
+	}
+	return NewFog(m.Absorption, m.Scattering, color, m.Anisotropy, m.Base, m.Falloff), nil
+}
+
+func (b sceneBuilder) volume(m mediumDescription) (Medium, error) {
+	color, err := m.color()
+	if err != nil {
+		return nil, err
+	}
+	switch m.Type {
+	case "homogeneous":
+		if m.Shape == nil {
+			return nil, fmt.Errorf("homogeneous volume needs a shape")
+		}
+		boundary, err := b.operand(*m.Shape)
+		if err != nil {
+			return nil, err
+		}
+		return NewHomogeneousVolume(boundary, m.Absorption, m.Scattering, color, m.Anisotropy), nil
+	case "grid":
+		if m.File == "" {
+			return nil, fmt.Errorf("grid volume needs a file")
+		}
+		grid, bounds, err := LoadDensityGrid(b.path(m.File))
+		if err != nil {
+			return nil, err
+		}
+		if m.Min != nil && m.Max != nil {
+			bounds = Bounds{m.Min.vec(), m.Max.vec()}
+		}
+		return NewGridVolume(grid, bounds, m.Absorption, m.Scattering, color, m.Anisotropy), nil
+	}
+	return nil, fmt.Errorf("unknown volume type %q", m.Type)
+}
+
+// Rotation en degrés, appliquée dans l'ordre X, Y puis Z
+type transformDescription struct {
+	Translate, Rotate vec3
+	Scale             *vec3
+}
+
+// matrix refuse les transformations non inversibles (échelle nulle), que
+// NewTransformed ne saurait pas appliquer aux rayons.
+func (t transformDescription) matrix() (Matrix4, error) {
+	scale := Vec3f{1, 1, 1}
+	if t.Scale != nil {
+		scale = t.Scale.vec()
+	}
+	m := trs(t.Translate.vec(), t.Rotate.vec().mul(math.Pi/180), scale)
+	if _, ok := m.inverse(); !ok {
+		return Matrix4{}, fmt.Errorf("transform is not invertible (scale %v)", scale)
+	}
+	return m, nil
+}
+
+// Tous les champs possibles des objets ; seuls ceux du type choisi sont lus.
+type objectDescription struct {
+	Type      string
+	Name      string
+	Material  string
+	Hidden    bool
+	Transform *transformDescription
+	Children  []objectDescription
+
+	Center, Point, Normal, Axis, Base, Min, Max, Size vec3
+	Radius, Height, Major, Minor                      float32
+	Rotate                                            vec3
+
+	// Objet "rectangle" : coin Corner et côtés U, V
+	Corner, U, V vec3
+
+	// Objet "mesh" : sommets et triangles (indices des sommets), ou Mesh,
+	// nom d'un maillage de "meshes" que partagent tous les objets qui le
+	// nomment (instances)
+	Vertices  []vec3
+	Triangles [][3]int
+	Mesh      string
+
+	// Objet "sdf" : champ de distance et budget de pas du lancer de sphères
+	Shape *shapeDescription
+	Steps int
+
+	// Objet "heightfield" : image en niveaux de gris ou bruit échantillonné
+	// sur Resolution² sommets, couvrant Size à partir du coin Min
+	Image      string
+	Noise      *noiseDescription
+	Resolution int
+}
+
+// Champ de distance : sphere, box, roundbox, torus, capsule, les opérations
+// lisses union, subtract et intersect (largeur k), repeat et twist.
+type shapeDescription struct {
+	Type     string
+	Children []shapeDescription
+
+	Center, Half, A, B, Period vec3
+	Radius, Major, Minor, K    float32
+	Rate                       float32
+	Limit                      [3]int
+}
+
+type sceneDescription struct {
+	Camera       *cameraDescription
+	LightSamples int
+	Textures     map[string]textureDescription
+	Materials    map[string]materialDescription
+	Lights       []lightDescription
+	Environment  *environmentDescription
+	Fog          *mediumDescription
+	Volumes      []mediumDescription
+	Meshes       map[string]meshDescription
+	Objects      []objectDescription
+}
+
+type meshDescription struct {
+	Vertices  []vec3
+	Triangles [][3]int
+}
+
+// loadScene lit une scène JSON. La caméra n'est modifiée que si le fichier
+// en décrit une.
+func loadScene(path string, camera Camera) (Scene, Camera, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Scene{}, camera, err
+	}
+	var desc sceneDescription
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return Scene{}, camera, fmt.Errorf("invalid scene file %s: %v", path, err)
+	}
+
+	if desc.Camera != nil {
+		camera.position = desc.Camera.Position.vec()
+		camera.up = desc.Camera.Up.vec()
+		camera.at = desc.Camera.At.vec()
+	}
+
+	builder := sceneBuilder{
+		materials: make(map[string]Materials),
+		textures:  make(map[string]Texture),
+		meshes:    make(map[string]*Mesh),
+		dir:       filepath.Dir(path),
+	}
+	for name, m := range desc.Meshes {
+		mesh, err := buildMesh(m.Vertices, m.Triangles)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("mesh %q: %v", name, err)
+		}
+		builder.meshes[name] = mesh
+	}
+	for name, t := range desc.Textures {
+		texture, err := builder.buildTexture(t)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("texture %q: %v", name, err)
+		}
+		builder.textures[name] = texture
+	}
+	// Les matériaux de terrain font référence aux autres : ils viennent en dernier
+	names := make([]string, 0, len(desc.Materials))
+	for name := range desc.Materials {
+		names = append(names, name)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return desc.Materials[names[i]].Type != "terrain" && desc.Materials[names[j]].Type == "terrain"
+	})
+	for _, name := range names {
+		material, err := builder.buildMaterial(desc.Materials[name])
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("material %q: %v", name, err)
+		}
+		builder.materials[name] = material
+	}
+
+	scene := Scene{lightSamples: desc.LightSamples}
+	if desc.Environment != nil {
+		env, err := builder.environment(*desc.Environment)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("environment: %v", err)
+		}
+		scene.environment = env
+	}
+	if desc.Fog != nil {
+		fog, err := builder.fog(*desc.Fog)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("fog: %v", err)
+		}
+		scene.media = append(scene.media, fog)
+	}
+	for i, v := range desc.Volumes {
+		volume, err := builder.volume(v)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("volume %d: %v", i, err)
+		}
+		scene.media = append(scene.media, volume)
+	}
+	for i, l := range desc.Lights {
+		light, err := l.build()
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("light %d: %v", i, err)
+		}
+		scene.addLight(light)
+	}
+	for i, o := range desc.Objects {
+		node, err := builder.node(o)
+		if err != nil {
+			return Scene{}, camera, fmt.Errorf("object %d (%s): %v", i, o.Name, err)
+		}
+		scene.addNode(node)
+	}
+	return scene, camera, nil
+}
+
+type sceneBuilder struct {
+	materials map[string]Materials
+	textures  map[string]Texture
+	meshes    map[string]*Mesh
+	dir       string // répertoire du fichier de scène
+}
+
+func (b sceneBuilder) buildTexture(t textureDescription) (Texture, error) {
+	switch t.Type {
+	case "image":
+		wrap := map[string]WrapMode{"": WrapRepeat, "repeat": WrapRepeat, "clamp": WrapClamp, "mirror": WrapMirror}
+		mode, ok := wrap[t.Wrap]
+		if !ok {
+			return nil, fmt.Errorf("unknown wrap mode %q", t.Wrap)
+		}
+		texture, err := LoadImageTexture(b.path(t.File), mode)
+		if err != nil {
+			return nil, err
+		}
+		switch t.Filter {
+		case "", "bilinear":
+		case "nearest":
+			texture.bilinear = false
+		default:
+			return nil, fmt.Errorf("unknown filter %q", t.Filter)
+		}
+		return texture, nil
+	case "checker":
+		scale := t.Scale
+		if scale == 0 {
+			scale = 8
+		}
+		return CheckerTexture{t.Even.vec(), t.Odd.vec(), scale}, nil
+	case "noise":
+		kinds := map[string]NoiseKind{"": PerlinNoise, "perlin": PerlinNoise, "simplex": SimplexNoise, "worley": WorleyNoise}
+		kind, ok := kinds[t.Noise]
+		if !ok {
+			return nil, fmt.Errorf("unknown noise %q", t.Noise)
+		}
+		scale := t.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		return NewNoiseTexture(kind, t.Seed, scale, t.Octaves, t.Low.vec(), t.High.vec()), nil
+	}
+	return nil, fmt.Errorf("unknown texture type %q", t.Type)
+}
+
+func (b sceneBuilder) texture(name string) (Texture, error) {
+	if name == "" {
+		return nil, nil
+	}
+	t, ok := b.textures[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown texture %q", name)
+	}
+	return t, nil
+}
+
+func (b sceneBuilder) buildMaterial(m materialDescription) (Materials, error) {
+	material, err := b.baseMaterial(m)
+	if err != nil || (m.NormalMap == "" && m.BumpMap == "") {
+		return material, err
+	}
+	normalMap, err := b.texture(m.NormalMap)
+	if err != nil {
+		return nil, err
+	}
+	bumpMap, err := b.texture(m.BumpMap)
+	if err != nil {
+		return nil, err
+	}
+	scale := m.BumpScale
+	if scale == 0 {
+		scale = 1
+	}
+	return NormalMapped{material, normalMap, bumpMap, scale}, nil
+}
+
+func (b sceneBuilder) baseMaterial(m materialDescription) (Materials, error) {
+	maps := make(map[string]Texture)
+	for param, name := range m.Textures {
+		switch param {
+		case "ka", "kd", "ks", "n", "baseColor", "metallic", "roughness":
+		default:
+			return nil, fmt.Errorf("unknown material parameter %q", param)
+		}
+		t, err := b.texture(name)
+		if err != nil {
+			return nil, err
+		}
+		maps[param] = t
+	}
+	color := m.Color.vec()
+	if (maps["kd"] != nil || maps["baseColor"] != nil) && color == (Vec3f{}) {
+		color = Vec3f{1, 1, 1}
+	}
+	if maps["ka"] == nil {
+		maps["ka"] = maps["kd"]
+	}
+
+	switch m.Type {
+	case "phong":
+		phong := NewPhongMaterial(color, m.Specular, m.Shininess)
+		phong.kaMap, phong.kdMap, phong.ksMap, phong.nMap = maps["ka"], maps["kd"], maps["ks"], maps["n"]
+		return phong, nil
+	case "lambert":
+		lambert := Lambert{kd: color, kdMap: maps["kd"]}
+		if m.Spectrum != nil {
+			reflectance, err := NewTabulatedSpectrum(m.Spectrum)
+			if err != nil {
+				return nil, err
+			}
+			lambert.kd, lambert.spectrum = spectrumRGB(reflectance), reflectance
+		}
+		return lambert, nil
+	case "glass":
+		ior := m.IOR
+		if ior == 0 {
+			ior = 1.5
+		}
+		if color == (Vec3f{}) {
+			color = Vec3f{1, 1, 1}
+		}
+		glass := NewDielectric(ior, color)
+		glass.abbe = m.Abbe
+		return glass, nil
+	case "subsurface":
+		radius := m.Radius.vec()
+		if radius == (Vec3f{}) {
+			radius = Vec3f{0.1, 0.1, 0.1}
+		}
+		return NewSubsurface(color, radius), nil
+	case "toon":
+		toon := NewToon(color, m.Bands, m.Specular, m.Rim)
+		toon.colorMap = maps["kd"]
+		if m.Bands == 0 {
+			toon.bands = 3
+		}
+		if m.Shininess > 0 {
+			toon.glossiness = m.Shininess
+		}
+		if m.RimWidth > 0 {
+			toon.rimWidth = m.RimWidth
+		}
+		toon.outline = m.Outline.vec()
+		if m.OutlineWidth != nil {
+			toon.outlineWidth = *m.OutlineWidth
+		}
+		return toon, nil
+	case "emissive":
+		strength := m.Strength
+		if strength == 0 {
+			strength = 1
+		}
+		emissive := Emissive{color: color, strength: strength}
+		if m.Spectrum != nil {
+			emission, err := NewTabulatedSpectrum(m.Spectrum)
+			if err != nil {
+				return nil, err
+			}
+			emissive.spectrum, emissive.color = normalizedSpectrum(emission)
+		}
+		return emissive, nil
+	case "pbr":
+		metallic, roughness := m.Metallic, m.Roughness
+		if maps["metallic"] != nil && metallic == 0 {
+			metallic = 1
+		}
+		if maps["roughness"] != nil && roughness == 0 {
+			roughness = 1
+		}
+		pbr := NewCookTorrance(color, metallic, roughness)
+		pbr.baseColorMap, pbr.metallicMap, pbr.roughnessMap = maps["baseColor"], maps["metallic"], maps["roughness"]
+		return pbr, nil
+	case "terrain":
+		terrain := TerrainMaterial{heightBlend: m.HeightBlend, slopeBlend: m.SlopeBlend}
+		for _, l := range m.Layers {
+			material, err := b.material(l.Material)
+			if err != nil {
+				return nil, err
+			}
+			if material == nil {
+				return nil, fmt.Errorf("terrain layer without material")
+			}
+			inf := float32(math.Inf(1))
+			layer := TerrainLayer{material, -inf, inf, 0, 90}
+			if l.Height != nil {
+				layer.minHeight, layer.maxHeight = l.Height[0], l.Height[1]
+			}
+			if l.Slope != nil {
+				layer.minSlope, layer.maxSlope = l.Slope[0], l.Slope[1]
+			}
+			terrain.layers = append(terrain.layers, layer)
+		}
+		return terrain, nil
+	}
+	return nil, fmt.Errorf("unknown material type %q", m.Type)
+}
+
+func (b sceneBuilder) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(b.dir, name)
+}
+
+func (b sceneBuilder) material(name string) (Materials, error) {
+	if name == "" {
+		return nil, nil
+	}
+	m, ok := b.materials[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown material %q", name)
+	}
+	return m, nil
+}
+
+func (b sceneBuilder) node(o objectDescription) (*Node, error) {
+	node := NewNode(o.Name)
+	if o.Transform != nil {
+		transform, err := o.Transform.matrix()
+		if err != nil {
+			return nil, err
+		}
+		node.transform = transform
+	}
+	node.hidden = o.Hidden
+
+	material, err := b.material(o.Material)
+	if err != nil {
+		return nil, err
+	}
+	node.material = material
+
+	if o.Type == "group" {
+		for _, child := range o.Children {
+			c, err := b.node(child)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %v", o.Name, child.Name, err)
+			}
+			node.add(c)
+		}
+		return node, nil
+	}
+
+	node.object, err = b.object(o)
+	return node, err
+}
+
+// object construit la géométrie seule ; la transformation et le matériau
+// sont portés par le nœud, sauf pour les opérandes CSG.
+func (b sceneBuilder) object(o objectDescription) (GeometricObject, error) {
+	switch o.Type {
+	case "union", "intersection", "difference":
+		if len(o.Children) < 2 {
+			return nil, fmt.Errorf("%s needs at least two children", o.Type)
+		}
+		operands := make([]GeometricObject, len(o.Children))
+		for i, child := range o.Children {
+			operand, err := b.operand(child)
+			if err != nil {
+				return nil, err
+			}
+			operands[i] = operand
+		}
+		// Les opérations s'enchaînent de gauche à droite : a - b - c = (a - b) - c
+		res := operands[0]
+		for _, operand := range operands[1:] {
+			switch o.Type {
+			case "union":
+				res = Union(res, operand)
+			case "intersection":
+				res = Intersection(res, operand)
+			default:
+				res = Difference(res, operand)
+			}
+		}
+		return res, nil
+	case "sphere":
+		return Sphere{o.Radius, o.Center.vec(), nil}, nil
+	case "plane":
+		return NewPlane(o.Point.vec(), o.Normal.vec(), nil), nil
+	case "disk":
+		return NewDisk(o.Center.vec(), o.Normal.vec(), o.Radius, nil), nil
+	case "rectangle":
+		return NewRectangle(o.Corner.vec(), o.U.vec(), o.V.vec(), nil), nil
+	case "mesh":
+		if o.Mesh == "" {
+			return buildMesh(o.Vertices, o.Triangles)
+		}
+		mesh, ok := b.meshes[o.Mesh]
+		if !ok {
+			return nil, fmt.Errorf("unknown mesh %q", o.Mesh)
+		}
+		return mesh, nil
+	case "box":
+		return Box{o.Min.vec(), o.Max.vec(), nil}, nil
+	case "orientedbox":
+		return NewOrientedBox(o.Center.vec(), o.Size.vec(), trs(Vec3f{}, o.Rotate.vec().mul(math.Pi/180), Vec3f{1, 1, 1}), nil), nil
+	case "cylinder":
+		return NewCylinder(o.Base.vec(), o.Axis.vec(), o.Radius, o.Height, nil), nil
+	case "cone":
+		return NewCone(o.Base.vec(), o.Axis.vec(), o.Radius, o.Height, nil), nil
+	case "torus":
+		return NewTorus(o.Center.vec(), o.Axis.vec(), o.Major, o.Minor, nil), nil
+	case "sdf":
+		if o.Shape == nil {
+			return nil, fmt.Errorf("sdf needs a shape")
+		}
+		shape, err := buildShape(*o.Shape)
+		if err != nil {
+			return nil, err
+		}
+		object := NewSDFObject(shape, nil)
+		if o.Steps > 0 {
+			object.steps = o.Steps
+		}
+		return object, nil
+	case "heightfield":
+		if o.Image != "" {
+			return NewHeightfieldFromImage(b.path(o.Image), o.Min.vec(), o.Size.vec(), nil)
+		}
+		if o.Noise == nil {
+			return nil, fmt.Errorf("heightfield needs an image or a noise")
+		}
+		octaves, frequency, resolution := max(o.Noise.Octaves, 1), o.Noise.Frequency, o.Resolution
+		if frequency == 0 {
+			frequency = 4
+		}
+		if resolution < 2 {
+			resolution = 256
+		}
+		return NewHeightfieldFromNoise(NewNoise(o.Noise.Seed), octaves, frequency, resolution, o.Min.vec(), o.Size.vec(), nil), nil
+	}
+	return nil, fmt.Errorf("unknown object type %q", o.Type)
+}
+
+func buildShape(s shapeDescription) (SDF, error) {
+	children := make([]SDF, len(s.Children))
+	for i, child := range s.Children {
+		c, err := buildShape(child)
+		if err != nil {
+			return nil, err
+		}
+		children[i] = c
+	}
+
+	switch s.Type {
+	case "sphere":
+		return SDFSphere{s.Center.vec(), s.Radius}, nil
+	case "box":
+		return SDFBox{s.Center.vec(), s.Half.vec()}, nil
+	case "roundbox":
+		return SDFRoundBox{s.Center.vec(), s.Half.vec(), s.Radius}, nil
+	case "torus":
+		return SDFTorus{s.Center.vec(), s.Major, s.Minor}, nil
+	case "capsule":
+		return SDFCapsule{s.A.vec(), s.B.vec(), s.Radius}, nil
+	case "union", "subtract", "intersect":
+		if len(children) < 2 {
+			return nil, fmt.Errorf("%s needs at least two children", s.Type)
+		}
+		res := children[0]
+		for _, c := range children[1:] {
+			switch s.Type {
+			case "union":
+				res = SmoothUnion(res, c, s.K)
+			case "subtract":
+				res = SmoothSubtract(res, c, s.K)
+			default:
+				res = SmoothIntersect(res, c, s.K)
+			}
+		}
+		return res, nil
+	case "repeat", "twist":
+		if len(children) != 1 {
+			return nil, fmt.Errorf("%s needs exactly one child", s.Type)
+		}
+		if s.Type == "repeat" {
+			return Repeat{children[0], s.Period.vec(), s.Limit}, nil
+		}
+		return Twist{children[0], s.Rate}, nil
+	}
+	return nil, fmt.Errorf("unknown shape type %q", s.Type)
+}
+
+func buildMesh(vertices []vec3, triangles [][3]int) (*Mesh, error) {
+	mesh := &Mesh{triangles: triangles}
+	for _, v := range vertices {
+		mesh.vertices = append(mesh.vertices, v.vec())
+	}
+	for _, tri := range triangles {
+		for _, i := range tri {
+			if i < 0 || i >= len(mesh.vertices) {
+				return nil, fmt.Errorf("mesh vertex index %d out of range", i)
+			}
+		}
+	}
+	return mesh, nil
+}
+
+// operand construit un opérande CSG avec son propre matériau et sa transformation.
+func (b sceneBuilder) operand(o objectDescription) (GeometricObject, error) {
+	object, err := b.object(o)
+	if err != nil {
+		return nil, err
+	}
+	material, err := b.material(o.Material)
+	if err != nil {
+		return nil, err
+	}
+	if material != nil {
+		object = inheritMaterial(object, material)
+	}
+	if o.Transform != nil {
+		transform, err := o.Transform.matrix()
+		if err != nil {
+			return nil, err
+		}
+		transformed, ok := NewTransformed(object, transform)
+		if !ok {
+			return nil, fmt.Errorf("transform is not invertible")
+		}
+		object = transformed
+	}
+	return object, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import "strings"
+
+// ------------------------------
+// Nœud du graphe de scène. Un nœud porte une transformation locale, un
+// matériau hérité par les objets qui n'en ont pas, un objet optionnel et
+// des enfants. Un nœud caché masque tout son sous-arbre.
+type Node struct {
+	name      string
+	transform Matrix4
+	material  Materials
+	hidden    bool
+	object    GeometricObject
+	parent    *Node
+	children  []*Node
+}
+
+func NewNode(name string) *Node {
+	return &Node{name: name, transform: identity()}
+}
+
+func NewObjectNode(name string, object GeometricObject) *Node {
+	n := NewNode(name)
+	n.object = object
+	return n
+}
+
+func (n *Node) add(child *Node) *Node {
+	if child.parent != nil {
+		child.parent.remove(child)
+	}
+	child.parent = n
+	n.children = append(n.children, child)
+	return child
+}
+
+func (n *Node) remove(child *Node) {
+	for i, c := range n.children {
+		if c == child {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			child.parent = nil
+			return
+		}
+	}
+}
+
+// find cherche un descendant par son chemin, par exemple "foret/sapin12".
+func (n *Node) find(path string) *Node {
+	current := n
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var next *Node
+		for _, child := range current.children {
+			if child.name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+func (n *Node) path() string {
+	if n.parent == nil {
+		return n.name
+	}
+	return strings.TrimPrefix(n.parent.path()+"/"+n.name, "/")
+}
+
+func (n *Node) setTransform(m Matrix4) {
+	n.transform = m
+}
+
+// translate déplace le nœud dans le repère de son parent.
+func (n *Node) translate(v Vec3f) {
+	n.transform = translation(v).mul(n.transform)
+}
+
+func (n *Node) hide() {
+	n.hidden = true
+}
+
+func (n *Node) show() {
+	n.hidden = false
+}
+
+// clone copie le sous-arbre, que l'animation peut modifier sans toucher
+// à la scène d'origine. Les objets sont partagés.
+func (n *Node) clone() *Node {
+	c := *n
+	c.parent = nil
+	c.children = nil
+	for _, child := range n.children {
+		c.add(child.clone())
+	}
+	return &c
+}
+
+// flatten aplatit le sous-arbre visible en objets placés dans le repère monde.
+// Un nœud dont la transformation n'est pas inversible (échelle nulle) est
+// écarté avec son sous-arbre, comme un nœud caché.
+func (n *Node) flatten(parentTransform Matrix4, parentMaterial Materials) []GeometricObject {
+	if n.hidden {
+		return nil
+	}
+	toWorld := parentTransform.mul(n.transform)
+	toObject, ok := toWorld.inverse()
+	if !ok {
+		return nil
+	}
+	material := parentMaterial
+	if n.material != nil {
+		material = n.material
+	}
+
+	var objects []GeometricObject
+	if n.object != nil {
+		object := n.object
+		if material != nil {
+			object = inheritMaterial(object, material)
+		}
+		if toWorld != identity() {
+			object = Transformed{object, toWorld, toObject}
+		}
+		objects = append(objects, object)
+	}
+	for _, child := range n.children {
+		objects = append(objects, child.flatten(toWorld, material)...)
+	}
+	return objects
+}
+
+// inheritMaterial donne le matériau m aux objets qui n'en ont pas.
+func inheritMaterial(object GeometricObject, m Materials) GeometricObject {
+	switch obj := object.(type) {
+	case Transformed:
+		obj.object = inheritMaterial(obj.object, m)
+		return obj
+	case Moving:
+		obj.object = inheritMaterial(obj.object, m)
+		return obj
+	case materialHolder:
+		if obj.material() == nil {
+			return obj.withMaterial(m)
+		}
+		return obj
+	}
+	return object
+}
+
+// Primitives dont le matériau peut être remplacé
+type materialHolder interface {
+	GeometricObject
+	material() Materials
+	withMaterial(m Materials) GeometricObject
+}
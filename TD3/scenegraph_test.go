@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// L'animation déplace et cache les nœuds d'une copie du graphe : la scène
+// d'origine ne bouge pas.
+func TestAnimationDrivesNodes(t *testing.T) {
+	var scene Scene
+	group := scene.addNode(NewNode("groupe"))
+	group.add(NewObjectNode("boule", Sphere{1, Vec3f{}, Lambert{kd: Vec3f{1, 1, 1}}}))
+
+	animation := NewAnimation(24)
+	move := animation.node("groupe")
+	move.translation.add(key(0, Vec3f{}))
+	move.translation.add(key(1, Vec3f{0, 10, 0}))
+	visible := animation.node("groupe/boule")
+	visible.visible.add(key(0, float32(0)))
+	visible.visible.add(key(1, float32(1)))
+
+	frame, _ := animation.evaluate(scene, Camera{}, 0)
+	if objects := frame.root.flatten(identity(), nil); len(objects) != 0 {
+		t.Errorf("%d objects at t=0, want the sphere hidden", len(objects))
+	}
+
+	frame, _ = animation.evaluate(scene, Camera{}, 1)
+	objects := frame.root.flatten(identity(), nil)
+	if len(objects) != 1 {
+		t.Fatalf("%d objects at t=1, want 1", len(objects))
+	}
+	if b := objects[0].bounds(); math.Abs(float64(b.min.y-9)) > 1e-4 || math.Abs(float64(b.max.y-11)) > 1e-4 {
+		t.Errorf("sphere spans y in [%v, %v] at t=1, want [9, 11]", b.min.y, b.max.y)
+	}
+
+	if scene.node("groupe").transform != identity() || scene.node("groupe/boule").hidden {
+		t.Errorf("the animation modified the original scene graph")
+	}
+	if len(scene.root.flatten(identity(), nil)) != 1 {
+		t.Errorf("the original scene graph lost its sphere")
+	}
+}
+
+// Une échelle nulle, par exemple la première clé d'une apparition, écarte
+// le nœud au lieu de faire échouer le rendu.
+func TestFlattenSkipsFlatNodes(t *testing.T) {
+	var scene Scene
+	scene.addNode(NewObjectNode("boule", Sphere{1, Vec3f{}, Lambert{kd: Vec3f{1, 1, 1}}}))
+
+	animation := NewAnimation(24)
+	grow := animation.node("boule")
+	grow.scale.add(key(0, Vec3f{}))
+	grow.scale.add(key(1, Vec3f{1, 1, 1}))
+
+	frame, _ := animation.evaluate(scene, Camera{}, 0)
+	if objects := frame.root.flatten(identity(), nil); len(objects) != 0 {
+		t.Errorf("%d objects at scale 0, want none", len(objects))
+	}
+	frame, _ = animation.evaluate(scene, Camera{}, 1)
+	if objects := frame.root.flatten(identity(), nil); len(objects) != 1 {
+		t.Errorf("%d objects at scale 1, want 1", len(objects))
+	}
+}
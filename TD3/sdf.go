@@ -0,0 +1,318 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Champ de distance signée : négatif à l'intérieur, positif à l'extérieur.
+// distance ne doit jamais surestimer la distance à la surface, sinon le
+// lancer de sphères peut la traverser.
+type SDF interface {
+	distance(p Vec3f) float32
+	extent() Bounds
+}
+
+func abs32(x float32) float32 {
+	return float32(math.Abs(float64(x)))
+}
+
+func sqrt32(x float32) float32 {
+	return float32(math.Sqrt(float64(x)))
+}
+
+func clamp32(x, lo, hi float32) float32 {
+	return max(lo, min(x, hi))
+}
+
+func absVec(v Vec3f) Vec3f {
+	return Vec3f{abs32(v.x), abs32(v.y), abs32(v.z)}
+}
+
+func maxVec(v Vec3f, f float32) Vec3f {
+	return Vec3f{max(v.x, f), max(v.y, f), max(v.z, f)}
+}
+
+func cubeBounds(center Vec3f, half float32) Bounds {
+	h := Vec3f{half, half, half}
+	return Bounds{Add(center, h.inverte()), Add(center, h)}
+}
+
+type SDFSphere struct {
+	center Vec3f
+	radius float32
+}
+
+func (s SDFSphere) distance(p Vec3f) float32 {
+	return Add(p, s.center.inverte()).norme() - s.radius
+}
+
+func (s SDFSphere) extent() Bounds {
+	return cubeBounds(s.center, s.radius)
+}
+
+// Boîte centrée sur center, de demi-dimensions half
+type SDFBox struct {
+	center, half Vec3f
+}
+
+func (b SDFBox) distance(p Vec3f) float32 {
+	q := Add(absVec(Add(p, b.center.inverte())), b.half.inverte())
+	return maxVec(q, 0).norme() + min(max(q.x, max(q.y, q.z)), 0)
+}
+
+func (b SDFBox) extent() Bounds {
+	return Bounds{Add(b.center, b.half.inverte()), Add(b.center, b.half)}
+}
+
+// Boîte aux arêtes arrondies de rayon radius, contenue dans half
+type SDFRoundBox struct {
+	center, half Vec3f
+	radius       float32
+}
+
+func (b SDFRoundBox) distance(p Vec3f) float32 {
+	r := Vec3f{b.radius, b.radius, b.radius}
+	return SDFBox{b.center, Add(b.half, r.inverte())}.distance(p) - b.radius
+}
+
+func (b SDFRoundBox) extent() Bounds {
+	return SDFBox{b.center, b.half}.extent()
+}
+
+// Tore d'axe Y
+type SDFTorus struct {
+	center       Vec3f
+	major, minor float32
+}
+
+func (t SDFTorus) distance(p Vec3f) float32 {
+	q := Add(p, t.center.inverte())
+	ring := sqrt32(q.x*q.x+q.z*q.z) - t.major
+	return sqrt32(ring*ring+q.y*q.y) - t.minor
+}
+
+func (t SDFTorus) extent() Bounds {
+	r := t.major + t.minor
+	return Bounds{Add(t.center, Vec3f{-r, -t.minor, -r}), Add(t.center, Vec3f{r, t.minor, r})}
+}
+
+// Capsule : segment [a, b] épaissi de radius
+type SDFCapsule struct {
+	a, b   Vec3f
+	radius float32
+}
+
+func (c SDFCapsule) distance(p Vec3f) float32 {
+	pa := Add(p, c.a.inverte())
+	ba := Add(c.b, c.a.inverte())
+	h := clamp32(Dot(pa, ba)/Dot(ba, ba), 0, 1)
+	return Add(pa, ba.mul(-h)).norme() - c.radius
+}
+
+func (c SDFCapsule) extent() Bounds {
+	return cubeBounds(c.a, c.radius).union(cubeBounds(c.b, c.radius))
+}
+
+// ------------------------------
+// Opérations lisses : k est la largeur du raccord, 0 donne l'opération franche.
+type SDFOperation int
+
+const (
+	SDFUnion SDFOperation = iota
+	SDFSubtract
+	SDFIntersect
+)
+
+type SmoothSDF struct {
+	operation SDFOperation
+	a, b      SDF
+	k         float32
+}
+
+func SmoothUnion(a, b SDF, k float32) SmoothSDF     { return SmoothSDF{SDFUnion, a, b, k} }
+func SmoothSubtract(a, b SDF, k float32) SmoothSDF  { return SmoothSDF{SDFSubtract, a, b, k} }
+func SmoothIntersect(a, b SDF, k float32) SmoothSDF { return SmoothSDF{SDFIntersect, a, b, k} }
+
+// smoothMin : minimum polynomial d'Inigo Quilez
+func smoothMin(a, b, k float32) float32 {
+	if k <= 0 {
+		return min(a, b)
+	}
+	h := max(k-abs32(a-b), 0) / k
+	return min(a, b) - h*h*k/4
+}
+
+func (s SmoothSDF) distance(p Vec3f) float32 {
+	a, b := s.a.distance(p), s.b.distance(p)
+	switch s.operation {
+	case SDFSubtract:
+		return -smoothMin(-a, b, s.k)
+	case SDFIntersect:
+		return -smoothMin(-a, -b, s.k)
+	}
+	return smoothMin(a, b, s.k)
+}
+
+// Le raccord d'une union lisse peut déborder de k/4 des deux formes.
+func (s SmoothSDF) extent() Bounds {
+	a, b := s.a.extent(), s.b.extent()
+	switch s.operation {
+	case SDFSubtract:
+		return a
+	case SDFIntersect:
+		return Bounds{
+			Vec3f{max(a.min.x, b.min.x), max(a.min.y, b.min.y), max(a.min.z, b.min.z)},
+			Vec3f{min(a.max.x, b.max.x), min(a.max.y, b.max.y), min(a.max.z, b.max.z)},
+		}
+	}
+	margin := Vec3f{s.k, s.k, s.k}.mul(0.25)
+	u := a.union(b)
+	return Bounds{Add(u.min, margin.inverte()), Add(u.max, margin)}
+}
+
+// ------------------------------
+// Repeat répète shape selon une grille de pas period, de -limit à +limit
+// cellules sur chaque axe. Un pas nul désactive la répétition sur cet axe.
+// La forme doit tenir dans une cellule pour que la distance reste valide.
+type Repeat struct {
+	shape  SDF
+	period Vec3f
+	limit  [3]int
+}
+
+func (r Repeat) distance(p Vec3f) float32 {
+	cell := func(x, period float32, limit int) float32 {
+		if period == 0 {
+			return x
+		}
+		n := clamp32(float32(math.Round(float64(x/period))), float32(-limit), float32(limit))
+		return x - period*n
+	}
+	q := Vec3f{
+		cell(p.x, r.period.x, r.limit[0]),
+		cell(p.y, r.period.y, r.limit[1]),
+		cell(p.z, r.period.z, r.limit[2]),
+	}
+	return r.shape.distance(q)
+}
+
+func (r Repeat) extent() Bounds {
+	b := r.shape.extent()
+	spread := Vec3f{
+		abs32(r.period.x) * float32(r.limit[0]),
+		abs32(r.period.y) * float32(r.limit[1]),
+		abs32(r.period.z) * float32(r.limit[2]),
+	}
+	return Bounds{Add(b.min, spread.inverte()), Add(b.max, spread)}
+}
+
+// Twist tord shape autour de l'axe Y de rate radians par unité de hauteur.
+type Twist struct {
+	shape SDF
+	rate  float32
+}
+
+// radius : plus grande distance à l'axe Y de la forme non tordue
+func (t Twist) radius() float32 {
+	b := t.shape.extent()
+	x := max(abs32(b.min.x), abs32(b.max.x))
+	z := max(abs32(b.min.z), abs32(b.max.z))
+	return sqrt32(x*x + z*z)
+}
+
+// La torsion étire l'espace : la distance est divisée par la constante de
+// Lipschitz pour que le lancer de sphères ne dépasse pas la surface.
+func (t Twist) distance(p Vec3f) float32 {
+	angle := float64(t.rate * p.y)
+	c, s := float32(math.Cos(angle)), float32(math.Sin(angle))
+	q := Vec3f{c*p.x - s*p.z, p.y, s*p.x + c*p.z}
+	k := t.rate * t.radius()
+	return t.shape.distance(q) / sqrt32(1+k*k)
+}
+
+func (t Twist) extent() Bounds {
+	b := t.shape.extent()
+	r := t.radius()
+	return Bounds{Vec3f{-r, b.min.y, -r}, Vec3f{r, b.max.y, r}}
+}
+
+// ------------------------------
+// SDFObject rend un champ de distance par lancer de sphères, limité à
+// steps pas. Il se mélange librement aux objets analytiques.
+type SDFObject struct {
+	shape    SDF
+	steps    int
+	Material Materials
+}
+
+const (
+	sdfDefaultSteps = 256
+	sdfEpsilon      = 1e-4
+	sdfGradientStep = 1e-3
+)
+
+func NewSDFObject(shape SDF, material Materials) SDFObject {
+	return SDFObject{shape, sdfDefaultSteps, material}
+}
+
+func (o SDFObject) bounds() Bounds {
+	return o.shape.extent()
+}
+
+func (o SDFObject) material() Materials {
+	return o.Material
+}
+
+func (o SDFObject) withMaterial(m Materials) GeometricObject {
+	o.Material = m
+	return o
+}
+
+// gradient : différences finies sur un tétraèdre, quatre évaluations.
+func (o SDFObject) gradient(p Vec3f) Vec3f {
+	const h = sdfGradientStep
+	k := [4]Vec3f{{1, -1, -1}, {-1, -1, 1}, {-1, 1, -1}, {1, 1, 1}}
+	var n Vec3f
+	for _, d := range k {
+		n = Add(n, d.mul(o.shape.distance(Add(p, d.mul(h)))))
+	}
+	return n.normalized()
+}
+
+// intersect marche le long du rayon à l'intérieur de la boîte englobante.
+// Un rayon qui part de l'intérieur cherche la sortie, comme pour les autres
+// primitives ; il doit d'abord quitter la surface d'où il part.
+func (o SDFObject) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	ok, entry := o.shape.extent().hit(ray, tmax)
+	if !ok {
+		return HitRecord{}, false
+	}
+	// La direction n'est pas forcément normalisée (rayons transformés)
+	scale := 1 / ray.direction.norme()
+
+	// Le côté se décide à l'origine du rayon : la surface peut toucher la
+	// boîte englobante, et le point d'entrée être déjà sur la surface
+	origin := ray.at(tmin)
+	d0 := o.shape.distance(origin)
+	side := float32(1)
+	if d0 < 0 || (d0 < sdfEpsilon && Dot(o.gradient(origin), ray.direction) < 0) {
+		side = -1
+	}
+
+	t := max(tmin, entry)
+	armed := abs32(d0) >= sdfEpsilon*max(1, tmin)
+	for i := 0; i < o.steps && t < tmax; i++ {
+		p := ray.at(t)
+		d := side * o.shape.distance(p)
+		eps := sdfEpsilon * max(1, t)
+		if d < eps {
+			if armed && t > tmin {
+				n := o.gradient(p)
+				return newHit(ray, t, n, boxUV(p, o.bounds().min, o.bounds().max), o.Material), true
+			}
+		} else {
+			armed = true
+		}
+		t += max(d, eps) * scale
+	}
+	return HitRecord{}, false
+}
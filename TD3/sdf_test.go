@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// Les surfaces qui touchent leur boîte englobante doivent renvoyer la face
+// avant, pas la sortie.
+func TestSDFObjectNearFace(t *testing.T) {
+	ray := Ray{Vec3f{0, 0, -10}, Vec3f{0, 0, 1}, 0}
+	shapes := map[string]SDF{
+		"box":    SDFBox{Vec3f{0, 0, 0}, Vec3f{3, 3, 3}},
+		"sphere": SDFSphere{Vec3f{0, 0, 0}, 3},
+	}
+	for name, shape := range shapes {
+		hit, ok := NewSDFObject(shape, nil).intersect(ray, 0, 100)
+		if !ok {
+			t.Fatalf("%s: no hit", name)
+		}
+		if abs32(hit.t-7) > 1e-2 || !hit.frontFace {
+			t.Errorf("%s: hit at t=%g frontFace=%v, want t=7 on the front face", name, hit.t, hit.frontFace)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ------------------------------
+// Rendu spectral : les rayons portent des longueurs d'onde plutôt que trois
+// canaux RVB. Les couleurs RVB des matériaux et des sources sont
+// suréchantillonnées en spectres lisses ; un matériau peut aussi donner un
+// spectre tabulé. Le film intègre la radiance contre les fonctions
+// colorimétriques CIE 1931, puis passe de XYZ au sRGB linéaire.
+
+const (
+	spectrumMin     = 380 // nm
+	spectrumMax     = 720
+	spectralSamples = 4 // longueurs d'onde par rayon de caméra
+)
+
+// Spectrum : valeur d'une grandeur spectrale (réflectance, émission) à une
+// longueur d'onde en nanomètres.
+type Spectrum interface {
+	at(lambda float32) float32
+}
+
+// TabulatedSpectrum interpole linéairement entre des mesures triées par
+// longueur d'onde ; hors de la table, il garde la valeur du bord.
+type TabulatedSpectrum struct {
+	lambdas []float32
+	values  []float32
+}
+
+func NewTabulatedSpectrum(samples [][2]float32) (TabulatedSpectrum, error) {
+	if len(samples) == 0 {
+		return TabulatedSpectrum{}, fmt.Errorf("empty spectrum")
+	}
+	s := TabulatedSpectrum{make([]float32, len(samples)), make([]float32, len(samples))}
+	for i, sample := range samples {
+		if i > 0 && sample[0] <= samples[i-1][0] {
+			return TabulatedSpectrum{}, fmt.Errorf("spectrum wavelengths must increase (%g after %g)", sample[0], samples[i-1][0])
+		}
+		s.lambdas[i], s.values[i] = sample[0], sample[1]
+	}
+	return s, nil
+}
+
+func (s TabulatedSpectrum) at(lambda float32) float32 {
+	i := sort.Search(len(s.lambdas), func(i int) bool { return s.lambdas[i] > lambda })
+	switch {
+	case i == 0:
+		return s.values[0]
+	case i == len(s.lambdas):
+		return s.values[len(s.values)-1]
+	}
+	u := (lambda - s.lambdas[i-1]) / (s.lambdas[i] - s.lambdas[i-1])
+	return s.values[i-1] + u*(s.values[i]-s.values[i-1])
+}
+
+func (s TabulatedSpectrum) scaled(k float32) TabulatedSpectrum {
+	res := TabulatedSpectrum{s.lambdas, make([]float32, len(s.values))}
+	for i, v := range s.values {
+		res.values[i] = v * k
+	}
+	return res
+}
+
+// ------------------------------
+// Fonctions colorimétriques CIE 1931, approchées par des sommes de
+// gaussiennes asymétriques (Wyman, Sloan et Shirley 2013).
+
+func lobe(lambda, mu, sigmaLow, sigmaHigh float32) float32 {
+	sigma := sigmaHigh
+	if lambda < mu {
+		sigma = sigmaLow
+	}
+	t := (lambda - mu) / sigma
+	return float32(math.Exp(float64(-t * t / 2)))
+}
+
+func colorMatching(lambda float32) Vec3f {
+	return Vec3f{
+		1.056*lobe(lambda, 599.8, 37.9, 31.0) + 0.362*lobe(lambda, 442.0, 16.0, 26.7) - 0.065*lobe(lambda, 501.1, 20.4, 26.2),
+		0.821*lobe(lambda, 568.8, 46.9, 40.5) + 0.286*lobe(lambda, 530.9, 16.3, 31.1),
+		1.217*lobe(lambda, 437.0, 11.8, 36.0) + 0.681*lobe(lambda, 459.0, 26.0, 13.8),
+	}
+}
+
+func xyzToLinearSRGB(c Vec3f) Vec3f {
+	return Vec3f{
+		3.2406*c.x - 1.5372*c.y - 0.4986*c.z,
+		-0.9689*c.x + 1.8758*c.y + 0.0415*c.z,
+		0.0557*c.x - 0.2040*c.y + 1.0570*c.z,
+	}
+}
+
+// spectralFilm porte l'équilibrage des blancs, qui rend blanc (1, 1, 1) le
+// spectre constant égal à 1, et les trois spectres de base du
+// suréchantillonnage avec l'inverse de leur matrice de couleurs.
+type spectralFilm struct {
+	white   Vec3f
+	inverse [3]Vec3f // lignes de l'inverse de la matrice des bases
+}
+
+var film = newSpectralFilm()
+
+func newSpectralFilm() spectralFilm {
+	f := spectralFilm{white: Vec3f{1, 1, 1}}
+	f.white = f.integrate(func(float32) float32 { return 1 })
+
+	// Colonnes : couleur de chaque base, bleue, verte puis rouge
+	var m [3]Vec3f
+	for i := 0; i < 3; i++ {
+		c := f.integrate(func(lambda float32) float32 { return spectralBasis(lambda)[i] })
+		m[0] = setAxis(m[0], i, c.x)
+		m[1] = setAxis(m[1], i, c.y)
+		m[2] = setAxis(m[2], i, c.z)
+	}
+	f.inverse = invert3(m)
+	return f
+}
+
+// integrate renvoie la couleur d'un spectre, intégré de nanomètre en
+// nanomètre sur le domaine visible.
+func (f spectralFilm) integrate(s func(float32) float32) Vec3f {
+	var xyz Vec3f
+	for lambda := float32(spectrumMin); lambda <= spectrumMax; lambda++ {
+		xyz = Add(xyz, colorMatching(lambda).mul(s(lambda)))
+	}
+	return f.rgb(xyz)
+}
+
+func (f spectralFilm) rgb(xyz Vec3f) Vec3f {
+	c := xyzToLinearSRGB(xyz)
+	return Vec3f{c.x / f.white.x, c.y / f.white.y, c.z / f.white.z}
+}
+
+// spectrumRGB : couleur sur le film d'un spectre, pour les rendus RVB.
+func spectrumRGB(s Spectrum) Vec3f {
+	return film.integrate(s.at)
+}
+
+// spectralBasis : trois spectres lisses, bleu, vert et rouge, de somme 1.
+func spectralBasis(lambda float32) [3]float32 {
+	blue := 1 - smoothstep(480, 510, lambda)
+	red := smoothstep(570, 600, lambda)
+	return [3]float32{blue, 1 - blue - red, red}
+}
+
+func setAxis(v Vec3f, i int, value float32) Vec3f {
+	switch i {
+	case 0:
+		v.x = value
+	case 1:
+		v.y = value
+	default:
+		v.z = value
+	}
+	return v
+}
+
+func invert3(m [3]Vec3f) [3]Vec3f {
+	c0 := cross(m[1], m[2])
+	c1 := cross(m[2], m[0])
+	c2 := cross(m[0], m[1])
+	inv := 1 / Dot(m[0], c0)
+	// Les colonnes de l'inverse sont les produits vectoriels des lignes
+	return [3]Vec3f{
+		Vec3f{c0.x, c1.x, c2.x}.mul(inv),
+		Vec3f{c0.y, c1.y, c2.y}.mul(inv),
+		Vec3f{c0.z, c1.z, c2.z}.mul(inv),
+	}
+}
+
+// upsampledSpectrum : combinaison des bases qui redonne exactement la
+// couleur rgb sur le film. Le blanc devient le spectre constant ; une
+// couleur très saturée peut demander une combinaison négative par
+// endroits, ramenée à zéro.
+type upsampledSpectrum struct {
+	coefficients Vec3f
+}
+
+func upsample(rgb Vec3f) upsampledSpectrum {
+	// Les coefficients sont dans l'ordre des bases : bleu, vert, rouge
+	inv := film.inverse
+	return upsampledSpectrum{Vec3f{Dot(inv[0], rgb), Dot(inv[1], rgb), Dot(inv[2], rgb)}}
+}
+
+func (s upsampledSpectrum) at(lambda float32) float32 {
+	b := spectralBasis(lambda)
+	return max(0, b[0]*s.coefficients.x+b[1]*s.coefficients.y+b[2]*s.coefficients.z)
+}
+
+// ------------------------------
+// Longueurs d'onde d'un chemin : une longueur d'onde héroïne tirée
+// uniformément et les autres décalées régulièrement sur le domaine (Wilkie
+// et al. 2014). Quand un rebond disperse la lumière, seule l'héroïne
+// continue et porte le poids des autres.
+type wavelengths struct {
+	lambda [spectralSamples]float32
+	single bool
+}
+
+func sampleWavelengths(u float32) wavelengths {
+	var w wavelengths
+	span := float32(spectrumMax - spectrumMin)
+	for i := range w.lambda {
+		offset := u*span + float32(i)*span/spectralSamples
+		w.lambda[i] = spectrumMin + float32(math.Mod(float64(offset), float64(span)))
+	}
+	return w
+}
+
+// collapse ne garde que l'héroïne ; il renvoie le facteur qui s'applique à
+// chaque longueur d'onde.
+func (w *wavelengths) collapse() spectralValues {
+	if w.single {
+		return spectralValues{1}
+	}
+	w.single = true
+	return spectralValues{spectralSamples}
+}
+
+// spectralValues : une grandeur aux longueurs d'onde du chemin.
+type spectralValues [spectralSamples]float32
+
+func constantValues(v float32) spectralValues {
+	var s spectralValues
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func spectrumValues(s Spectrum, w *wavelengths) spectralValues {
+	var res spectralValues
+	for i, lambda := range w.lambda {
+		res[i] = s.at(lambda)
+	}
+	return res
+}
+
+func (s spectralValues) add(o spectralValues) spectralValues {
+	for i := range s {
+		s[i] += o[i]
+	}
+	return s
+}
+
+func (s spectralValues) mul(o spectralValues) spectralValues {
+	for i := range s {
+		s[i] *= o[i]
+	}
+	return s
+}
+
+func (s spectralValues) scale(k float32) spectralValues {
+	for i := range s {
+		s[i] *= k
+	}
+	return s
+}
+
+func (s spectralValues) max() float32 {
+	return max(s[0], s[1], s[2], s[3])
+}
+
+// rgb estime la couleur sur le film : chaque longueur d'onde est un
+// échantillon de densité uniforme sur le domaine.
+func (s spectralValues) rgb(w *wavelengths) Vec3f {
+	var xyz Vec3f
+	for i, lambda := range w.lambda {
+		xyz = Add(xyz, colorMatching(lambda).mul(s[i]))
+	}
+	return film.rgb(xyz.mul(float32(spectrumMax-spectrumMin) / spectralSamples))
+}
+
+// ------------------------------
+// Matériaux spectraux : BSDF qui dépendent de la longueur d'onde
+// au-delà d'une couleur RVB (spectre tabulé, dispersion). Les autres BSDF
+// sont suréchantillonnés.
+type spectralBSDF interface {
+	evalSpectral(hit HitRecord, wo, wi Vec3f, w *wavelengths) spectralValues
+	sampleSpectral(hit HitRecord, wo Vec3f, w *wavelengths, rng *Rng) (Vec3f, spectralValues, bool)
+}
+
+func evalSpectral(b BSDF, hit HitRecord, wo, wi Vec3f, w *wavelengths) spectralValues {
+	if s, ok := b.(spectralBSDF); ok {
+		return s.evalSpectral(hit, wo, wi, w)
+	}
+	return spectrumValues(upsample(b.eval(hit, wo, wi)), w)
+}
+
+func sampleSpectral(b BSDF, hit HitRecord, wo Vec3f, w *wavelengths, rng *Rng) (Vec3f, spectralValues, bool) {
+	if s, ok := b.(spectralBSDF); ok {
+		return s.sampleSpectral(hit, wo, w, rng)
+	}
+	wi, weight, ok := b.sample(hit, wo, rng)
+	return wi, spectrumValues(upsample(weight), w), ok
+}
+
+// reflectance : spectre de la réflectance diffuse, la texture éventuelle
+// étant suréchantillonnée.
+func (l Lambert) reflectance(hit HitRecord, w *wavelengths) spectralValues {
+	r := spectrumValues(l.spectrum, w)
+	if l.kdMap != nil {
+		r = r.mul(spectrumValues(upsample(modulate(Vec3f{1, 1, 1}, l.kdMap, hit)), w))
+	}
+	return r
+}
+
+func (l Lambert) evalSpectral(hit HitRecord, wo, wi Vec3f, w *wavelengths) spectralValues {
+	if l.spectrum == nil {
+		return spectrumValues(upsample(l.eval(hit, wo, wi)), w)
+	}
+	cos := Dot(hit.shadingNormal, wi)
+	if cos <= 0 {
+		return spectralValues{}
+	}
+	return l.reflectance(hit, w).scale(cos / math.Pi)
+}
+
+func (l Lambert) sampleSpectral(hit HitRecord, wo Vec3f, w *wavelengths, rng *Rng) (Vec3f, spectralValues, bool) {
+	if l.spectrum == nil {
+		wi, weight, ok := l.sample(hit, wo, rng)
+		return wi, spectrumValues(upsample(weight), w), ok
+	}
+	wi := cosineHemisphere(hit.shadingNormal, rng.float(), rng.float())
+	return wi, l.reflectance(hit, w), true
+}
+
+func (d Dielectric) evalSpectral(hit HitRecord, wo, wi Vec3f, w *wavelengths) spectralValues {
+	return spectralValues{}
+}
+
+// sampleSpectral suit la longueur d'onde héroïne. Un verre dispersif
+// sépare les longueurs d'onde : les autres s'arrêtent.
+func (d Dielectric) sampleSpectral(hit HitRecord, wo Vec3f, w *wavelengths, rng *Rng) (Vec3f, spectralValues, bool) {
+	r, t, F, refracts := d.splitIOR(hit, wo, d.iorAt(w.lambda[0]))
+	if !refracts || rng.float() < F {
+		return r, constantValues(1), true
+	}
+	weight := spectrumValues(upsample(d.tint), w)
+	if d.abbe > 0 {
+		weight = weight.mul(w.collapse())
+	}
+	return t, weight, true
+}
+
+// ------------------------------
+// SpectralPathTracer : tracé de chemins spectral, sur le modèle de
+// PathTracer (éclairage direct et environnement à chaque rebond, émission
+// comptée en vue directe et après un rebond spéculaire, roulette russe).
+// Les milieux participants, la diffusion sous la surface et les photons ne
+// sont pas suivis : un matériau qui n'est pas un BSDF termine le chemin par
+// son rendu direct suréchantillonné.
+type SpectralPathTracer struct {
+	maxDepth int
+}
+
+func (p SpectralPathTracer) radiance(scene Scene, ray Ray, rng *Rng) Vec3f {
+	w := sampleWavelengths(rng.float())
+	var L spectralValues
+	throughput := constantValues(1)
+	bsdfPdf := float32(0)
+	delta := false
+	for depth := 0; depth < p.maxDepth; depth++ {
+		hit, found := scene.closest(ray)
+		if !found {
+			if scene.environment != nil {
+				weight := float32(1)
+				if depth > 0 && !delta {
+					weight = powerHeuristic(bsdfPdf, scene.environment.pdf(ray.direction))
+				}
+				L = L.add(throughput.mul(spectrumValues(upsample(scene.background(ray)), &w)).scale(weight))
+			}
+			break
+		}
+		if hit.material == nil {
+			break
+		}
+		if e, ok := hit.material.(Emissive); ok {
+			if (depth == 0 || delta || !scene.sampledEmitter(hit)) && e.emits(hit) {
+				L = L.add(throughput.mul(e.emission(&w)))
+			}
+			break
+		}
+		bsdf, hit, ok := asBSDF(hit)
+		if !ok {
+			L = L.add(throughput.mul(spectrumValues(upsample(hit.material.render(ray, hit, scene)), &w)))
+			break
+		}
+
+		wo := ray.direction.inverte().normalized()
+		delta = isDelta(bsdf)
+		if !delta {
+			for _, light := range scene.sampleLights(ray, hit, rng) {
+				if light.visible {
+					f := evalSpectral(bsdf, hit, wo, light.direction, &w)
+					L = L.add(throughput.mul(f).mul(light.emission(&w)))
+				}
+			}
+			L = L.add(throughput.mul(spectralEnvironment(bsdf, ray, hit, scene, &w, rng)))
+		}
+
+		wi, weight, ok := sampleSpectral(bsdf, hit, wo, &w, rng)
+		if !ok {
+			break
+		}
+		bsdfPdf = bsdf.pdf(hit, wo, wi)
+		throughput = throughput.mul(weight)
+		if depth >= rouletteDepth {
+			survive := min(0.95, throughput.max())
+			if rng.float() >= survive {
+				break
+			}
+			throughput = throughput.scale(1 / survive)
+		}
+		ray = Ray{hit.position, wi, ray.time}
+	}
+	return L.rgb(&w)
+}
+
+// spectralEnvironment : version spectrale de environmentLighting.
+func spectralEnvironment(b BSDF, ray Ray, hit HitRecord, scene Scene, w *wavelengths, rng *Rng) spectralValues {
+	if scene.environment == nil {
+		return spectralValues{}
+	}
+	wi, lightPdf := scene.environment.sample(rng.float(), rng.float())
+	if lightPdf <= 0 || scene.occluded(hit.position, wi, float32(math.Inf(1)), ray.time) {
+		return spectralValues{}
+	}
+	wo := ray.direction.inverte().normalized()
+	f := evalSpectral(b, hit, wo, wi, w)
+	weight := powerHeuristic(lightPdf, b.pdf(hit, wo, wi))
+	return f.mul(spectrumValues(upsample(scene.environment.radiance(wi)), w)).scale(weight / lightPdf)
+}
+
+// emission : spectre émis, tabulé ou suréchantillonné.
+func (e Emissive) emission(w *wavelengths) spectralValues {
+	if e.spectrum != nil {
+		return spectrumValues(e.spectrum, w).scale(e.strength)
+	}
+	return spectrumValues(upsample(e.radiance()), w)
+}
+
+// emission : spectre de l'échantillon de lumière. La couleur d'un
+// échantillon est celle de la source multipliée par un facteur géométrique,
+// qui se retrouve dans sa luminance.
+func (l lightSample) emission(w *wavelengths) spectralValues {
+	if l.spectrum != nil {
+		return spectrumValues(l.spectrum, w).scale(luminance(l.color))
+	}
+	return spectrumValues(upsample(l.color), w)
+}
+
+// normalizedSpectrum ramène un spectre d'émission à une couleur de
+// luminance 1, qu'il renvoie avec lui.
+func normalizedSpectrum(s TabulatedSpectrum) (TabulatedSpectrum, Vec3f) {
+	color := spectrumRGB(s)
+	if l := luminance(color); l > 0 {
+		return s.scaled(1 / l), color.mul(1 / l)
+	}
+	return s, color
+}
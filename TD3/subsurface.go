@@ -0,0 +1,129 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Subsurface : diffusion sous la surface par marche aléatoire (peau, cire,
+// marbre). La lumière entre par une transmission diffuse, marche dans
+// l'objet, un milieu isotrope de libre parcours moyen radius par canal,
+// et ressort ailleurs par une transmission diffuse. color est l'albédo vu
+// de loin : il est converti en albédo de diffusion simple (Christensen et
+// Burley 2015). Seul le tracé de chemins suit la marche ; le rendu direct
+// éclaire le matériau comme un Lambert de couleur color.
+type Subsurface struct {
+	color  Vec3f
+	radius Vec3f
+}
+
+func NewSubsurface(color, radius Vec3f) Subsurface {
+	return Subsurface{color, radius}
+}
+
+const (
+	subsurfaceMaxSteps    = 256
+	subsurfaceMinRadius   = 1e-4
+	subsurfaceRouletteMin = 16 // pas avant la roulette russe
+)
+
+// subsurfaceAlbedo inverse la relation entre albédo de diffusion simple et
+// albédo multiple d'un milieu semi-infini.
+func subsurfaceAlbedo(a float32) float32 {
+	a = clamp32(a, 0, 0.999)
+	s := 4.09712 + 4.20863*a - sqrt32(9.59217+41.6808*a+17.7126*a*a)
+	return 1 - s*s
+}
+
+func (s Subsurface) coefficients() (Vec3f, Vec3f) {
+	sigmaT := Vec3f{
+		1 / max(s.radius.x, subsurfaceMinRadius),
+		1 / max(s.radius.y, subsurfaceMinRadius),
+		1 / max(s.radius.z, subsurfaceMinRadius),
+	}
+	albedo := Vec3f{subsurfaceAlbedo(s.color.x), subsurfaceAlbedo(s.color.y), subsurfaceAlbedo(s.color.z)}
+	return sigmaT, Mul(sigmaT, albedo)
+}
+
+// walk entre dans l'objet au point hit et marche jusqu'à en ressortir. Il
+// renvoie l'impact de sortie, tourné vers l'extérieur et dont le matériau
+// est une transmission diffuse blanche, et le poids de la marche. Les
+// distances sont tirées selon un canal choisi en proportion du poids
+// courant et pondérées par le mélange des densités des trois canaux, ce
+// qui garde le poids borné.
+func (s Subsurface) walk(scene Scene, hit HitRecord, time float32, rng *Rng) (HitRecord, Vec3f, bool) {
+	sigmaT, sigmaS := s.coefficients()
+	outward := hit.geometricNormal
+	if !hit.frontFace {
+		outward = outward.inverte()
+	}
+	position := hit.position
+	direction := cosineHemisphere(outward.inverte(), rng.float(), rng.float())
+	weight := Vec3f{1, 1, 1}
+
+	for step := 0; step < subsurfaceMaxSteps; step++ {
+		total := weight.x + weight.y + weight.z
+		if total <= 0 {
+			return HitRecord{}, Vec3f{}, false
+		}
+		p := weight.mul(1 / total)
+		channel := 2
+		if u := rng.float(); u < p.x {
+			channel = 0
+		} else if u < p.x+p.y {
+			channel = 1
+		}
+		t := -float32(math.Log(float64(1-rng.float()))) / axis(sigmaT, channel)
+
+		ray := Ray{position, direction, time}
+		exit, found := scene.closest(ray)
+		if !found {
+			// Objet ouvert : la marche s'échappe
+			return HitRecord{}, Vec3f{}, false
+		}
+		leaves := exit.t <= t
+		if leaves {
+			t = exit.t
+		}
+		transmittance := Vec3f{
+			float32(math.Exp(float64(-sigmaT.x * t))),
+			float32(math.Exp(float64(-sigmaT.y * t))),
+			float32(math.Exp(float64(-sigmaT.z * t))),
+		}
+		if leaves {
+			// Probabilité de franchir t, mélangée sur les canaux
+			pdf := Dot(p, transmittance)
+			if pdf <= 0 {
+				return HitRecord{}, Vec3f{}, false
+			}
+			weight = Mul(weight, transmittance).mul(1 / pdf)
+			exit.shadingNormal = exit.geometricNormal
+			if exit.frontFace {
+				exit.shadingNormal = exit.geometricNormal.inverte()
+			}
+			exit.frontFace = true
+			exit.material = Lambert{kd: Vec3f{1, 1, 1}}
+			return exit, weight, true
+		}
+
+		pdf := Dot(p, Mul(sigmaT, transmittance))
+		if pdf <= 0 {
+			return HitRecord{}, Vec3f{}, false
+		}
+		weight = Mul(weight, Mul(sigmaS, transmittance)).mul(1 / pdf)
+		if step >= subsurfaceRouletteMin {
+			survive := min(0.95, max(weight.x, weight.y, weight.z))
+			if rng.float() >= survive {
+				return HitRecord{}, Vec3f{}, false
+			}
+			weight = weight.mul(1 / survive)
+		}
+		position = ray.at(t)
+		direction = uniformSphere(rng.float(), rng.float())
+	}
+	return HitRecord{}, Vec3f{}, false
+}
+
+func (s Subsurface) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	return Lambert{kd: s.color}.render(ray, hit, scene)
+}
+
+func (s Subsurface) albedo(hit HitRecord) Vec3f { return s.color }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"os"
+)
+
+// ------------------------------
+// Une texture donne une couleur au point d'impact, à partir de ses
+// coordonnées UV ou de sa position.
+type Texture interface {
+	value(uv Vec2f, p Vec3f) Vec3f
+}
+
+// modulate multiplie le facteur constant d'un paramètre de matériau par la
+// texture qui lui est associée, s'il y en a une.
+func modulate(factor Vec3f, t Texture, hit HitRecord) Vec3f {
+	if t == nil {
+		return factor
+	}
+	return Mul(factor, t.value(hit.uv, hit.position))
+}
+
+// ------------------------------
+// Damier en UV : scale cases par unité de texture
+type CheckerTexture struct {
+	even, odd Vec3f
+	scale     float32
+}
+
+func (c CheckerTexture) value(uv Vec2f, p Vec3f) Vec3f {
+	u := int(math.Floor(float64(uv.x * c.scale)))
+	v := int(math.Floor(float64(uv.y * c.scale)))
+	if (u+v)%2 == 0 {
+		return c.even
+	}
+	return c.odd
+}
+
+// ------------------------------
+// Texture image. Les UV hors de [0, 1] sont ramenés selon le mode de
+// répétition ; v = 0 correspond au haut de l'image.
+type WrapMode int
+
+const (
+	WrapRepeat WrapMode = iota
+	WrapClamp
+	WrapMirror
+)
+
+type ImageTexture struct {
+	pixels        []Vec3f
+	width, height int
+	wrap          WrapMode
+	bilinear      bool
+}
+
+// LoadImageTexture lit une image PNG ou JPEG, filtrée bilinéairement.
+func LoadImageTexture(path string, wrap WrapMode) (ImageTexture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImageTexture{}, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return ImageTexture{}, fmt.Errorf("texture %s: %v", path, err)
+	}
+
+	r := img.Bounds()
+	t := ImageTexture{make([]Vec3f, r.Dx()*r.Dy()), r.Dx(), r.Dy(), wrap, true}
+	for y := 0; y < t.height; y++ {
+		for x := 0; x < t.width; x++ {
+			cr, cg, cb, _ := img.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			t.pixels[y*t.width+x] = Vec3f{float32(cr), float32(cg), float32(cb)}.mul(1.0 / 0xffff)
+		}
+	}
+	return t, nil
+}
+
+// wrapIndex ramène l'indice de texel i dans [0, n[.
+func (t ImageTexture) wrapIndex(i, n int) int {
+	switch t.wrap {
+	case WrapClamp:
+		return max(0, min(i, n-1))
+	case WrapMirror:
+		period := 2 * n
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	}
+	return ((i % n) + n) % n
+}
+
+func (t ImageTexture) texel(x, y int) Vec3f {
+	return t.pixels[t.wrapIndex(y, t.height)*t.width+t.wrapIndex(x, t.width)]
+}
+
+func (t ImageTexture) value(uv Vec2f, p Vec3f) Vec3f {
+	// Centres des texels en (i + 0.5) / n
+	x := uv.x*float32(t.width) - 0.5
+	y := uv.y*float32(t.height) - 0.5
+	x0, y0 := int(math.Floor(float64(x))), int(math.Floor(float64(y)))
+	if !t.bilinear {
+		return t.texel(int(math.Floor(float64(x+0.5))), int(math.Floor(float64(y+0.5))))
+	}
+	fx, fy := x-float32(x0), y-float32(y0)
+	top := lerpVec3f(t.texel(x0, y0), t.texel(x0+1, y0), fx)
+	bottom := lerpVec3f(t.texel(x0, y0+1), t.texel(x0+1, y0+1), fx)
+	return lerpVec3f(top, bottom, fy)
+}
+
+// ------------------------------
+// Textures de bruit, évaluées en 3D à la position de l'impact (texture
+// solide) : la valeur du bruit mélange low et high.
+type NoiseKind int
+
+const (
+	PerlinNoise NoiseKind = iota
+	SimplexNoise
+	WorleyNoise
+)
+
+type NoiseTexture struct {
+	noise     *Noise
+	kind      NoiseKind
+	scale     float32
+	octaves   int
+	low, high Vec3f
+}
+
+func NewNoiseTexture(kind NoiseKind, seed int64, scale float32, octaves int, low, high Vec3f) NoiseTexture {
+	return NoiseTexture{NewNoise(seed), kind, scale, max(octaves, 1), low, high}
+}
+
+func (n NoiseTexture) value(uv Vec2f, p Vec3f) Vec3f {
+	p = p.mul(n.scale)
+	var v float32
+	switch n.kind {
+	case SimplexNoise:
+		v = 0.5 + 0.5*fbm(n.noise.simplex, p, n.octaves)
+	case WorleyNoise:
+		v = fbm(n.noise.worley, p, n.octaves)
+	default:
+		v = 0.5 + 0.5*fbm(n.noise.perlin, p, n.octaves)
+	}
+	return lerpVec3f(n.low, n.high, clamp32(v, 0, 1))
+}
@@ -0,0 +1,186 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Matériau cartoon (cel shading) : diffus quantifié en bandes, reflet
+// spéculaire franc et liseré de contre-jour (rim) sur les bords éclairés.
+// outlineWidth > 0 demande un contour de cette largeur en pixels, tracé
+// après le rendu par outlineTile.
+type Toon struct {
+	color    Vec3f
+	colorMap Texture
+	bands    int
+	shadow   float32 // luminosité de la bande non éclairée
+
+	specular   Vec3f
+	glossiness float32 // exposant de Blinn avant le seuil
+
+	rim      Vec3f
+	rimWidth float32 // part du bord couverte par le liseré, entre 0 et 1
+
+	outline      Vec3f
+	outlineWidth int
+}
+
+func NewToon(color Vec3f, bands int, specular, rim float32) Toon {
+	return Toon{
+		color:        color,
+		bands:        max(bands, 1),
+		shadow:       0.2,
+		specular:     Vec3f{specular, specular, specular},
+		glossiness:   32,
+		rim:          Vec3f{rim, rim, rim},
+		rimWidth:     0.3,
+		outlineWidth: 1,
+	}
+}
+
+// Seuil du reflet : au-dessus, le terme de Blinn vaut 1, en dessous 0
+const toonSpecularCutoff = 0.5
+
+func (t Toon) render(ray Ray, hit HitRecord, scene Scene) Vec3f {
+	kd := modulate(t.color, t.colorMap, hit)
+	n := hit.shadingNormal
+	view := ray.direction.inverte().normalized()
+	edge := 1 - max(0, Dot(n, view))
+
+	var res Vec3f
+	for _, light := range scene.sampleLights(ray, hit, positionRng(hit.position)) {
+		cos := Dot(n, light.direction)
+		lit := light.visible && cos > 0
+		level := float32(0)
+		if lit {
+			level = float32(math.Ceil(float64(cos*float32(t.bands)))) / float32(t.bands)
+		}
+		res = Add(res, Mul(kd, light.color.mul(t.shadow+(1-t.shadow)*level)))
+		if !lit {
+			continue
+		}
+		half := Add(light.direction, view).normalized()
+		if float32(math.Pow(float64(max(0, Dot(n, half))), float64(t.glossiness))) > toonSpecularCutoff {
+			res = Add(res, Mul(t.specular, light.color))
+		}
+		if edge > 1-t.rimWidth {
+			res = Add(res, Mul(t.rim, light.color))
+		}
+	}
+	return res
+}
+
+func (t Toon) albedo(hit HitRecord) Vec3f { return modulate(t.color, t.colorMap, hit) }
+
+// toonOf renvoie le matériau cartoon sous une éventuelle carte de normales.
+func toonOf(m Materials) (Toon, bool) {
+	if mapped, ok := m.(NormalMapped); ok {
+		m = mapped.material
+	}
+	t, ok := m.(Toon)
+	return t, ok
+}
+
+// ------------------------------
+// Contours : sur les pixels d'un matériau cartoon, un voisin à moins de
+// outlineWidth pixels qui est plus loin (silhouette), appartient à un autre
+// objet (intersection) ou dont la normale tourne de plus de
+// outlineCreaseAngle (arête vive) prend la couleur du contour. Les
+// silhouettes sont tracées du côté de l'objet le plus proche.
+
+const (
+	outlineDepthTolerance = 0.02 // écart relatif de profondeur par pixel
+	outlineCreaseAngle    = 40   // degrés
+)
+
+// outlineGuide : ce que voit le rayon central d'un pixel.
+type outlineGuide struct {
+	found    bool
+	depth    float32
+	normal   Vec3f
+	objectID int
+	toon     Toon
+	isToon   bool
+}
+
+// outlineMargin renvoie la plus grande largeur de contour des matériaux de la
+// scène préparée, 0 s'il n'y a rien à tracer.
+func outlineMargin(scene Scene) int {
+	margin := 0
+	for _, m := range scene.materialTable {
+		if t, ok := toonOf(m); ok {
+			margin = max(margin, t.outlineWidth)
+		}
+	}
+	return margin
+}
+
+// outlineTile trace les contours dans radiance, la tuile [startX, endX[ ×
+// [startY, endY[. Les rayons guides débordent de la tuile de la largeur du
+// contour : les tuiles voisines tracent les mêmes traits.
+func outlineTile(scene Scene, camera Camera, radiance []Vec3f, startX, startY, endX, endY, width, height int) {
+	margin := outlineMargin(scene)
+	if margin == 0 {
+		return
+	}
+	x0, y0 := max(startX-margin, 0), max(startY-margin, 0)
+	x1, y1 := min(endX+margin, width), min(endY+margin, height)
+	guideWidth := x1 - x0
+	guides := make([]outlineGuide, guideWidth*(y1-y0))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			ray := camera.ray(x, y, width, height, camera.shutter.open)
+			hit, found := scene.closest(ray)
+			if !found {
+				continue
+			}
+			g := &guides[(y-y0)*guideWidth+(x-x0)]
+			g.found = true
+			g.depth = Dot(Add(hit.position, camera.position.inverte()), camera.direction())
+			g.normal = hit.shadingNormal
+			g.objectID = hit.objectID
+			g.toon, g.isToon = toonOf(hit.material)
+		}
+	}
+
+	crease := float32(math.Cos(outlineCreaseAngle * math.Pi / 180))
+	tileWidth := endX - startX
+	for y := startY; y < endY; y++ {
+		for x := startX; x < endX; x++ {
+			p := guides[(y-y0)*guideWidth+(x-x0)]
+			w := p.toon.outlineWidth
+			if !p.isToon || w <= 0 {
+				continue
+			}
+			edge := false
+			for dy := -w; dy <= w && !edge; dy++ {
+				for dx := -w; dx <= w && !edge; dx++ {
+					qx, qy := x+dx, y+dy
+					if dx*dx+dy*dy > w*w || qx < x0 || qx >= x1 || qy < y0 || qy >= y1 {
+						continue
+					}
+					q := guides[(qy-y0)*guideWidth+(qx-x0)]
+					distance := float32(max(abs(dx), abs(dy)))
+					switch {
+					case !q.found:
+						edge = true
+					case q.depth-p.depth > outlineDepthTolerance*distance*p.depth:
+						edge = true
+					case q.objectID != p.objectID && q.depth >= p.depth:
+						edge = true
+					case Dot(p.normal, q.normal) < crease:
+						edge = true
+					}
+				}
+			}
+			if edge {
+				radiance[(y-startY)*tileWidth+(x-startX)] = p.toon.outline
+			}
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
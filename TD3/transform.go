@@ -0,0 +1,195 @@
+package main
+
+import "math"
+
+// ------------------------------
+// Matrice 4x4 (ligne, colonne) appliquée à des vecteurs colonnes : p' = M p
+type Matrix4 [4][4]float32
+
+func identity() Matrix4 {
+	return Matrix4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func translation(v Vec3f) Matrix4 {
+	m := identity()
+	m[0][3], m[1][3], m[2][3] = v.x, v.y, v.z
+	return m
+}
+
+func scaling(v Vec3f) Matrix4 {
+	m := identity()
+	m[0][0], m[1][1], m[2][2] = v.x, v.y, v.z
+	return m
+}
+
+// rotation autour d'un axe quelconque (formule de Rodrigues), angle en radians
+func rotation(axis Vec3f, angle float32) Matrix4 {
+	a := axis.normalized()
+	s := float32(math.Sin(float64(angle)))
+	c := float32(math.Cos(float64(angle)))
+	k := 1 - c
+	return Matrix4{
+		{c + a.x*a.x*k, a.x*a.y*k - a.z*s, a.x*a.z*k + a.y*s, 0},
+		{a.y*a.x*k + a.z*s, c + a.y*a.y*k, a.y*a.z*k - a.x*s, 0},
+		{a.z*a.x*k - a.y*s, a.z*a.y*k + a.x*s, c + a.z*a.z*k, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func rotationX(angle float32) Matrix4 { return rotation(Vec3f{1, 0, 0}, angle) }
+func rotationY(angle float32) Matrix4 { return rotation(Vec3f{0, 1, 0}, angle) }
+func rotationZ(angle float32) Matrix4 { return rotation(Vec3f{0, 0, 1}, angle) }
+
+// trs compose une translation, une rotation (angles d'Euler en radians,
+// appliqués dans l'ordre X, Y puis Z) et une mise à l'échelle.
+func trs(t, r, s Vec3f) Matrix4 {
+	return translation(t).mul(rotationZ(r.z)).mul(rotationY(r.y)).mul(rotationX(r.x)).mul(scaling(s))
+}
+
+func (m Matrix4) mul(o Matrix4) Matrix4 {
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				res[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return res
+}
+
+func (m Matrix4) transposed() Matrix4 {
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			res[i][j] = m[j][i]
+		}
+	}
+	return res
+}
+
+// det3 : déterminant de la partie linéaire (3x3) de m
+func (m Matrix4) det3() float32 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// inverse par élimination de Gauss-Jordan ; renvoie false si la matrice
+// n'est pas inversible.
+func (m Matrix4) inverse() (Matrix4, bool) {
+	var a [4][8]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			a[i][j] = float64(m[i][j])
+		}
+		a[i][i+4] = 1
+	}
+
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return Matrix4{}, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		p := a[col][col]
+		for j := 0; j < 8; j++ {
+			a[col][j] /= p
+		}
+		for row := 0; row < 4; row++ {
+			if row != col {
+				f := a[row][col]
+				for j := 0; j < 8; j++ {
+					a[row][j] -= f * a[col][j]
+				}
+			}
+		}
+	}
+
+	var res Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			res[i][j] = float32(a[i][j+4])
+		}
+	}
+	return res, true
+}
+
+func (m Matrix4) transformPoint(p Vec3f) Vec3f {
+	return Vec3f{
+		m[0][0]*p.x + m[0][1]*p.y + m[0][2]*p.z + m[0][3],
+		m[1][0]*p.x + m[1][1]*p.y + m[1][2]*p.z + m[1][3],
+		m[2][0]*p.x + m[2][1]*p.y + m[2][2]*p.z + m[2][3],
+	}
+}
+
+func (m Matrix4) transformVector(v Vec3f) Vec3f {
+	return Vec3f{
+		m[0][0]*v.x + m[0][1]*v.y + m[0][2]*v.z,
+		m[1][0]*v.x + m[1][1]*v.y + m[1][2]*v.z,
+		m[2][0]*v.x + m[2][1]*v.y + m[2][2]*v.z,
+	}
+}
+
+// transformNormal applique la transposée de m. Appelée sur la matrice
+// monde -> objet, elle ramène une normale de l'objet dans le repère monde.
+func (m Matrix4) transformNormal(n Vec3f) Vec3f {
+	return m.transposed().transformVector(n)
+}
+
+// La direction n'est pas renormalisée : le paramètre t reste le même dans
+// les deux repères.
+func (m Matrix4) transformRay(r Ray) Ray {
+	return Ray{m.transformPoint(r.origin), m.transformVector(r.direction), r.time}
+}
+
+// ------------------------------
+// Transformed place n'importe quel GeometricObject dans la scène. Les rayons
+// sont ramenés dans le repère de l'objet par la transformation inverse.
+// L'objet enveloppé n'est pas copié : plusieurs Transformed peuvent partager
+// le même *Mesh (instances).
+type Transformed struct {
+	object   GeometricObject
+	toWorld  Matrix4
+	toObject Matrix4
+}
+
+// NewTransformed renvoie false si toWorld n'est pas inversible (échelle
+// nulle) : les rayons ne pourraient pas être ramenés dans le repère objet.
+func NewTransformed(object GeometricObject, toWorld Matrix4) (Transformed, bool) {
+	toObject, ok := toWorld.inverse()
+	if !ok {
+		return Transformed{}, false
+	}
+	return Transformed{object, toWorld, toObject}, true
+}
+
+func NewInstance(mesh *Mesh, toWorld Matrix4) (Transformed, bool) {
+	return NewTransformed(mesh, toWorld)
+}
+
+func (m Transformed) bounds() Bounds {
+	return m.object.bounds().transformed(m.toWorld)
+}
+
+// Les normales reviennent dans le repère monde par la transposée de l'inverse.
+func (m Transformed) intersect(ray Ray, tmin, tmax float32) (HitRecord, bool) {
+	hit, ok := m.object.intersect(m.toObject.transformRay(ray), tmin, tmax)
+	if !ok {
+		return hit, false
+	}
+	world := newHit(ray, hit.t, m.toObject.transformNormal(hit.geometricNormal).normalized(), hit.uv, hit.material)
+	world.tangent = m.toWorld.transformVector(hit.tangent)
+	return world, true
+}